@@ -1,16 +1,16 @@
 package main
 
 import (
-	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -43,12 +43,21 @@ func atof(q string, def float64) float64 {
 	return v
 }
 
+// beaconParams reads ?beacon=<base url>&round=<n> (round 0 = latest).
+func beaconParams(q url.Values) (string, uint64) {
+	r, _ := strconv.ParseUint(q.Get("round"), 10, 64)
+	return q.Get("beacon"), r
+}
+
 // ======= handlers =======
 func generateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !requireSelfTest(w) {
+		return
+	}
 
 	q := r.URL.Query()
 
@@ -73,6 +82,8 @@ func generateHandler(w http.ResponseWriter, r *http.Request) {
 			HTTP:   []string{"https://candle.api.chaos.izvenyaisya.ru/last_seed"},
 		},
 		Whiten: strings.ToLower(q.Get("whiten")),
+		DRBG:   strings.ToLower(q.Get("drbg")),
+		PRNG:   simPRNGCurrent,
 	}
 	if gp.Motion.Law == "" {
 		gp.Motion.Law = "flow"
@@ -90,6 +101,7 @@ func generateHandler(w http.ResponseWriter, r *http.Request) {
 	if httpList := q.Get("http"); httpList != "" {
 		gp.Entropy.HTTP = strings.Split(httpList, ",")
 	}
+	gp.Entropy.Beacon, gp.Entropy.Round = beaconParams(q)
 
 	// Per-parameter defaults (use the user's standard values when specific param missing)
 	// Count already defaults to 1_000_000 in constructor above.
@@ -110,14 +122,80 @@ func generateHandler(w http.ResponseWriter, r *http.Request) {
 		gp.Whiten = "hybrid"
 	}
 
+	if _, ok := lookupEntropySource(gp.Entropy.Mode); !ok {
+		http.Error(w, "unknown entropy mode: "+gp.Entropy.Mode, http.StatusBadRequest)
+		return
+	}
+	if gp.DRBG == "" {
+		gp.DRBG = defaultDRBG
+	} else if _, ok := drbgMechanisms[gp.DRBG]; !ok {
+		http.Error(w, "unknown drbg: "+gp.DRBG+" (known: "+strings.Join(drbgNames(), ",")+")", http.StatusBadRequest)
+		return
+	}
+	for _, law := range lawChoices(gp.Motion.Law) {
+		if _, ok := motionLaws[law]; !ok {
+			http.Error(w, "unknown law: "+law+" (known: "+strings.Join(motionLawNames(), ",")+", random)", http.StatusBadRequest)
+			return
+		}
+	}
+	// параметры законов (lorenz.rho=...) пишутся в MotionSpec.Params
+	if err := resolveMotionParams(&gp.Motion, q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// взаимодействие точек (interact.go)
+	gp.Motion.Collide = q.Get("collide") == "1" || q.Get("collide") == "true"
+	gp.Motion.CollideRadius = atof(q.Get("radius"), 0)
+	gp.Motion.Attract = atof(q.Get("attract"), 0)
+	gp.Motion.AttractRange = atof(q.Get("attract_range"), 0)
+	gp.Motion.Flock = atof(q.Get("flock"), 0)
+	gp.Motion.FlockRange = atof(q.Get("flock_range"), 0)
+	resolveInteractions(&gp.Motion)
+	gate, err := chaosGateFrom(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	log.Printf("generate: starting generation (count=%d, whiten=%s, law=%s, entropy=%s)", gp.Count, gp.Whiten, gp.Motion.Law, gp.Entropy.Mode)
 	// 1) получаем мастер-seed
-	seed, entropyTag, perSeeds := deriveSeed(gp.Entropy)
+	ent, err := deriveSeed(gp.Entropy)
+	if err != nil {
+		log.Printf("generate: entropy error: %v", err)
+		http.Error(w, "entropy: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	seed, entropyTag, perSeeds := ent.Seed, ent.Tag, ent.PerSeeds
+	if ent.BeaconRound != 0 {
+		gp.Entropy.Round = ent.BeaconRound
+	}
 	log.Printf("generate: derived seed=%d tag=%s", seed, entropyTag)
 
+	// порог хаоса: параметры с вырожденной динамикой отклоняются до генерации
+	var chaos *ChaosSummary
+	if gate.enabled() {
+		rep := chaosDiagnostics(seed, gp, chaosIters(gp))
+		if err := gate.check(rep); err != nil {
+			log.Printf("generate: rejected by chaos gate: %v", err)
+			rep.PointVariance = nil
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_ = json.NewEncoder(w).Encode(map[string]any{"error": "chaos gate: " + err.Error(), "chaos": rep})
+			return
+		}
+		chaos = &ChaosSummary{Iterations: rep.Iterations, Lyapunov: rep.Lyapunov, VisitEntropy: rep.VisitEntropy, Gate: gate}
+	}
+
 	// 2) запускаем симуляцию
 	log.Printf("generate: starting simulation for tx (seed=%d) iterations=%d points=%d", seed, gp.Iterations, gp.NumPoints)
-	sim, digest := runSimulation(seed, gp)
+	// траектории нужны только для кэша картинок; большой прогон — только digest
+	var sim SimulationData
+	var digest [32]byte
+	if simRenderable(gp) {
+		sim, digest = runSimulation(seed, gp)
+	} else {
+		digest = simulationDigest(seed, gp)
+	}
 	log.Printf("generate: simulation complete for seed=%d", seed)
 
 	// 3) из digest разворачиваем итоговые биты (с режимом whitening)
@@ -154,33 +232,42 @@ func generateHandler(w http.ResponseWriter, r *http.Request) {
 		CreatedAt: time.Now().UTC(),
 		Count:     gp.Count,
 		Seed:      seed,
-		Sim:       sim,
 		DataHash:  hex.EncodeToString(dh[:]),
 		BitsHash:  bitsHash,
 		Published: hex.EncodeToString(published[:]),
 		Provenance: GenerationProvenance{
-			Entropy:      gp.Entropy,
-			Motion:       gp.Motion,
-			Iterations:   gp.Iterations,
-			NumPoints:    gp.NumPoints,
-			PixelWidth:   gp.PixelWidth,
-			CanvasW:      gp.CanvasW,
-			CanvasH:      gp.CanvasH,
-			Step:         gp.Step,
-			Whiten:       gp.Whiten,
-			PerHTTPSeeds: perSeeds,
+			Entropy:        gp.Entropy,
+			Motion:         gp.Motion,
+			Iterations:     gp.Iterations,
+			NumPoints:      gp.NumPoints,
+			PixelWidth:     gp.PixelWidth,
+			CanvasW:        gp.CanvasW,
+			CanvasH:        gp.CanvasH,
+			Step:           gp.Step,
+			Whiten:         gp.Whiten,
+			PerHTTPSeeds:   perSeeds,
+			HealthFailures: ent.HealthFailures,
+			DRBG:           gp.DRBG,
+			BeaconRound:    ent.BeaconRound,
+			FloatMode:      floatModeStrict,
+			PRNG:           gp.PRNG,
+			Chaos:          chaos,
 		},
 	}
 	// добавим тег выбранного источника (удобно видеть в /info)
 	tx.Provenance.Entropy.Mode = entropyTag
 
 	// 6) сохраняем
-	txMutex.Lock()
-	tx.Provenance.Entropy.Mode = entropyTag
-
-	txStore[tx.TxID] = tx
-	txMutex.Unlock()
-	appendBlock(tx)
+	if err := appendBlock(tx); err != nil {
+		http.Error(w, "store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// симуляция живёт в кэше (simcache.go), а не в транзакции; режим — как в
+	// provenance, чтобы пересобранная симуляция совпадала с исходной
+	if len(sim.Points) > 0 {
+		sim.EntropyMode = entropyTag
+		keepSimulation(tx.TxID, sim)
+	}
 	log.Printf("generate: created tx %s seed=%d count=%d", tx.TxID, seed, gp.Count)
 
 	// 7) ответ
@@ -190,19 +277,27 @@ func generateHandler(w http.ResponseWriter, r *http.Request) {
 		"seed":       seed,
 		"count":      gp.Count,
 		"replay_hint": map[string]any{
-			"entropy_mode": gp.Entropy.Mode,
-			"seed":         seed, // достаточно для воспроизведения
-			"law":          gp.Motion.Law,
-			"sharp":        gp.Motion.Sharpness,
-			"smooth":       gp.Motion.Smoothness,
-			"speed":        gp.Motion.SpeedScale,
-			"iter":         gp.Iterations,
-			"points":       gp.NumPoints,
-			"w":            gp.CanvasW,
-			"h":            gp.CanvasH,
-			"px":           gp.PixelWidth,
-			"step":         gp.Step,
-			"whiten":       gp.Whiten,
+			"entropy_mode":  gp.Entropy.Mode,
+			"seed":          seed, // достаточно для воспроизведения
+			"law":           gp.Motion.Law,
+			"law_params":    gp.Motion.Params,
+			"collide":       gp.Motion.Collide,
+			"radius":        gp.Motion.CollideRadius,
+			"attract":       gp.Motion.Attract,
+			"attract_range": gp.Motion.AttractRange,
+			"flock":         gp.Motion.Flock,
+			"flock_range":   gp.Motion.FlockRange,
+			"sharp":         gp.Motion.Sharpness,
+			"smooth":        gp.Motion.Smoothness,
+			"speed":         gp.Motion.SpeedScale,
+			"iter":          gp.Iterations,
+			"points":        gp.NumPoints,
+			"w":             gp.CanvasW,
+			"h":             gp.CanvasH,
+			"px":            gp.PixelWidth,
+			"step":          gp.Step,
+			"whiten":        gp.Whiten,
+			"drbg":          gp.DRBG,
 		},
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -217,21 +312,16 @@ func generateTierHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !requireSelfTest(w) {
+		return
+	}
 	q := r.URL.Query()
 	min := atoi(q.Get("min"), 1)
 	max := atoi(q.Get("max"), 49)
-	if max < min {
-		http.Error(w, "max must be >= min", http.StatusBadRequest)
-		return
-	}
 	n := atoi(q.Get("n"), 10)
-	if n <= 0 {
-		http.Error(w, "n must be > 0", http.StatusBadRequest)
-		return
-	}
 	t := atoi(q.Get("t"), 1)
-	if t <= 0 || t > n {
-		http.Error(w, "t must be >0 and <= n", http.StatusBadRequest)
+	if err := validateTier(min, max, n, t); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -241,6 +331,7 @@ func generateTierHandler(w http.ResponseWriter, r *http.Request) {
 		entMode = "mix"
 	}
 	gpEntropy := EntropySpec{Mode: entMode}
+	gpEntropy.Beacon, gpEntropy.Round = beaconParams(q)
 	if seedStr := q.Get("seed"); seedStr != "" {
 		if s, err := strconv.ParseInt(seedStr, 10, 64); err == nil {
 			gpEntropy.Seed64 = s
@@ -248,56 +339,59 @@ func generateTierHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	seed, tag, perSeeds := deriveSeed(gpEntropy)
-
-	// initialize TRNG and sample without replacement using Fisher–Yates driven by TRNG
-	tr := NewTRNGFromSeed(seed, perSeeds)
-	rngRange := max - min + 1
-	if n > rngRange {
-		http.Error(w, "n must be <= (max-min+1)", http.StatusBadRequest)
+	if _, ok := lookupEntropySource(gpEntropy.Mode); !ok {
+		http.Error(w, "unknown entropy mode: "+gpEntropy.Mode, http.StatusBadRequest)
 		return
 	}
-
-	// build pool [min..max]
-	poolSize := rngRange
-	pool := make([]int, 0, poolSize)
-	for v := min; v <= max; v++ {
-		pool = append(pool, v)
+	// раунд маяка публичен, а beacon= и round= выбирает вызывающий: seed из
+	// уже вышедшего раунда можно подобрать под нужных победителей. В розыгрыш
+	// маяк попадает только через commit–reveal, последним per-seed поверх
+	// секретной энтропии (drawReveal)
+	if gpEntropy.Mode == "beacon" {
+		http.Error(w, "entropy=beacon is not accepted for tier draws: use POST /draw/commit with \"beacon\"", http.StatusBadRequest)
+		return
 	}
-
-	// Fisher–Yates shuffle using TRNG as randomness source
-	for i := poolSize - 1; i > 0; i-- {
-		b := tr.ReadBytes(8)
-		j := int(binary.LittleEndian.Uint64(b) % uint64(i+1))
-		pool[i], pool[j] = pool[j], pool[i]
+	drbgName := strings.ToLower(q.Get("drbg"))
+	if drbgName == "" {
+		drbgName = defaultDRBG
+	} else if _, ok := drbgMechanisms[drbgName]; !ok {
+		http.Error(w, "unknown drbg: "+drbgName+" (known: "+strings.Join(drbgNames(), ",")+")", http.StatusBadRequest)
+		return
 	}
 
-	// first n items are our unique numbers
-	nums := make([]int, n)
-	copy(nums, pool[:n])
-
-	// select t unique winners from the n numbers by shuffling indices using TRNG
-	idxs := make([]int, n)
-	for i := range idxs {
-		idxs[i] = i
+	ent, err := deriveSeed(gpEntropy)
+	if err != nil {
+		log.Printf("generate-tier: entropy error: %v", err)
+		http.Error(w, "entropy: "+err.Error(), http.StatusServiceUnavailable)
+		return
 	}
-	for i := n - 1; i > 0; i-- {
-		b := tr.ReadBytes(8)
-		j := int(binary.LittleEndian.Uint64(b) % uint64(i+1))
-		idxs[i], idxs[j] = idxs[j], idxs[i]
+	seed, tag, perSeeds := ent.Seed, ent.Tag, ent.PerSeeds
+	if ent.BeaconRound != 0 {
+		gpEntropy.Round = ent.BeaconRound
 	}
-	winners := make([]int, t)
-	for k := 0; k < t; k++ {
-		winners[k] = nums[idxs[k]]
+
+	// initialize TRNG and sample without replacement using Fisher–Yates driven by TRNG
+	tr, err := NewTRNG(drbgName, seed, perSeeds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// pr=1: prediction resistance, DRBG пересеивается из источника перед каждым чтением.
+	// Такой розыгрыш нельзя воспроизвести из seed.
+	pr := q.Get("pr") == "1" || q.Get("pr") == "true"
+	if pr {
+		src, _ := lookupEntropySource(gpEntropy.Mode)
+		if err := tr.EnablePredictionResistance(src, gpEntropy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
-	// compute signature over payload: txid will be added after tx created; sign numbers+winners+seed
-	payloadStruct := map[string]any{
-		"seed":    seed,
-		"numbers": nums,
-		"winners": winners,
+	nums, winners, err := drawTier(tr, drawAlgoCurrent, min, max, n, t)
+	if err != nil {
+		http.Error(w, "trng: "+err.Error(), http.StatusServiceUnavailable)
+		return
 	}
-	payloadB, _ := json.Marshal(payloadStruct)
 
 	// create transaction
 	tx := &Transaction{
@@ -310,51 +404,81 @@ func generateTierHandler(w http.ResponseWriter, r *http.Request) {
 		BitsHash:  "",
 		Published: "",
 		Provenance: GenerationProvenance{
-			Entropy:      gpEntropy,
-			Motion:       MotionSpec{},
-			Iterations:   0,
-			NumPoints:    0,
-			PixelWidth:   0,
-			CanvasW:      0,
-			CanvasH:      0,
-			Step:         0,
-			Whiten:       "",
-			PerHTTPSeeds: perSeeds,
+			Entropy:              gpEntropy,
+			Motion:               MotionSpec{},
+			Iterations:           0,
+			NumPoints:            0,
+			PixelWidth:           0,
+			CanvasW:              0,
+			CanvasH:              0,
+			Step:                 0,
+			Whiten:               "",
+			PerHTTPSeeds:         perSeeds,
+			HealthFailures:       ent.HealthFailures,
+			PredictionResistance: pr,
+			DRBG:                 drbgName,
+			BeaconRound:          ent.BeaconRound,
 		},
-		TierNumbers: nums,
-		TierWinners: winners,
+		TierNumbers:   nums,
+		TierWinners:   winners,
+		TierRange:     []int{min, max},
+		DrawAlgorithm: drawAlgoCurrent,
 	}
 
 	// annotate provenance mode with human-readable tag
 	tx.Provenance.Entropy.Mode = tag
 
-	// sign payload
-	sig := signTierPayload(payloadB)
-	tx.Signature = sig
+	// sign payload (Ed25519, verifiable with the key from /keys)
+	signTier(tx)
 
 	// store and publish minimal block info: use Published field to store signature's hex as published
-	tx.Published = sig
+	tx.Published = tx.Signature
 
-	txMutex.Lock()
-	txStore[tx.TxID] = tx
-	txMutex.Unlock()
-	appendBlock(tx)
+	if err := appendBlock(tx); err != nil {
+		http.Error(w, "store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	resp := map[string]any{
 		"tx_id":     tx.TxID,
 		"numbers":   nums,
 		"winners":   winners,
-		"signature": sig,
+		"signature": tx.Signature,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
 }
 
+// GET /chain[?from=N&to=M] — блоки [from, to), по умолчанию вся цепочка.
 func chainHandler(w http.ResponseWriter, r *http.Request) {
-	chainMutex.RLock()
-	defer chainMutex.RUnlock()
+	size := chainLen()
+	from, to := 0, size
+	if s := r.URL.Query().Get("from"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "bad from", http.StatusBadRequest)
+			return
+		}
+		from = n
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < from {
+			http.Error(w, "bad to", http.StatusBadRequest)
+			return
+		}
+		to = min(n, size)
+	}
+	blocks := make([]Block, 0, max(to-from, 0))
+	if err := scanBlocks(from, to, func(b Block) bool {
+		blocks = append(blocks, b)
+		return true
+	}); err != nil {
+		http.Error(w, "store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(chain)
+	_ = json.NewEncoder(w).Encode(blocks)
 }
 
 // /tx/{id}/png  /json  /txt  /bin  /verify  /info  /reproduce
@@ -394,40 +518,43 @@ func txRouter(w http.ResponseWriter, r *http.Request) {
 		txTier(w, r, id)
 	case "verify-signature":
 		txVerifySignature(w, r, id)
+	case "verify-draw":
+		txVerifyDraw(w, r, id)
+	case "proof":
+		txProof(w, r, id)
+	case "chaos":
+		txChaos(w, r, id)
 	default:
 		log.Printf("txRouter: unknown action '%s' for tx %s", action, id)
 		http.Error(w, "unknown tx action", http.StatusNotFound)
 	}
 }
 
-// txVerifySignature recomputes payload and verifies stored HMAC signature using in-memory signingKey
+// txVerifySignature checks the tier signature (Ed25519, or HMAC for older
+// draws) and the Ed25519 signature over the whole transaction.
 func txVerifySignature(w http.ResponseWriter, r *http.Request, id string) {
 	tx := mustTx(id, w)
 	if tx == nil {
 		return
 	}
-	// payload used at signing time: {seed, numbers, winners}
-	payloadStruct := map[string]any{
-		"seed":    tx.Seed,
-		"numbers": tx.TierNumbers,
-		"winners": tx.TierWinners,
-	}
-	payloadB, _ := json.Marshal(payloadStruct)
-
-	// compute expected signature using signingKey
-	if len(signingKey) == 0 {
-		initSigningKey()
-	}
-	mac := hmac.New(sha256.New, signingKey)
-	mac.Write(payloadB)
-	expected := fmt.Sprintf("%x", mac.Sum(nil))
-
+	keys := currentKeys()
+	txMutex.RLock()
+	match, expected := keys.verifyTier(tx)
 	out := map[string]any{
 		"tx_id":           tx.TxID,
-		"signature_match": expected == tx.Signature,
-		"expected":        expected,
+		"signature_alg":   tierSignatureAlg(tx),
+		"key_id":          tx.KeyID,
+		"signature_match": match,
 		"actual":          tx.Signature,
 	}
+	if expected != "" {
+		out["expected"] = expected
+	}
+	if tx.TxSignature != "" {
+		out["tx_key_id"] = tx.TxKeyID
+		out["tx_signature_valid"] = keys.verifyTx(tx)
+	}
+	txMutex.RUnlock()
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(out)
 }
@@ -452,8 +579,13 @@ func txPNG(w http.ResponseWriter, r *http.Request, id string) {
 	if tx == nil {
 		return
 	}
+	sim, err := simulationFor(tx)
+	if err != nil {
+		http.Error(w, err.Error(), simErrorStatus(err))
+		return
+	}
 	w.Header().Set("Content-Type", "image/png")
-	if err := writePNG(w, tx.Sim); err != nil {
+	if err := writePNG(w, sim); err != nil {
 		log.Printf("png err: %v", err)
 	}
 }
@@ -462,8 +594,13 @@ func txJSON(w http.ResponseWriter, r *http.Request, id string) {
 	if tx == nil {
 		return
 	}
+	sim, err := simulationFor(tx)
+	if err != nil {
+		http.Error(w, err.Error(), simErrorStatus(err))
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(tx.Sim)
+	_ = json.NewEncoder(w).Encode(sim)
 }
 func txTXT(w http.ResponseWriter, r *http.Request, id string) {
 	// Alias to /tx/{id}/trng?format=bin&type=txt
@@ -500,32 +637,31 @@ func txVerify(w http.ResponseWriter, r *http.Request, id string) {
 		"published_in_chain": false,
 	}
 
+	keys := currentKeys()
 	// Special-case: tier (lottery) transactions don't have a SimulationData path
 	// and therefore cannot be verified by re-running the simulation. For those
-	// we verify the stored HMAC signature over {seed, numbers, winners} and
-	// treat data/bits match as the signature match result.
+	// we verify the stored tier signature (see keySet.verifyTier) and
+	// treat data/bits match as the signature match result. Key rotation
+	// records are checked against the previous key's signature.
 	if len(tx.TierNumbers) > 0 || len(tx.TierWinners) > 0 {
-		// recompute payload
-		payload := map[string]any{
-			"seed":    tx.Seed,
-			"numbers": tx.TierNumbers,
-			"winners": tx.TierWinners,
-		}
-		pb, _ := json.Marshal(payload)
-		// ensure signingKey is available
-		if len(signingKey) == 0 {
-			initSigningKey()
-		}
-		mac := hmac.New(sha256.New, signingKey)
-		mac.Write(pb)
-		expected := fmt.Sprintf("%x", mac.Sum(nil))
-		sigMatch := expected == tx.Signature
+		txMutex.RLock()
+		sigMatch, _ := keys.verifyTier(tx)
+		txMutex.RUnlock()
 		resp["data_hash_match"] = sigMatch
 		resp["bits_hash_match"] = sigMatch
+	} else if tx.KeyRotation != nil {
+		b, _ := json.Marshal(tx.KeyRotation)
+		h := sha256.Sum256(b)
+		ok := hex.EncodeToString(h[:]) == tx.Published && keys.verifyRotation(tx.KeyRotation)
+		resp["data_hash_match"] = ok
+		resp["bits_hash_match"] = ok
+	} else if err := checkSimulationModes(&tx.Provenance); err != nil {
+		// транзакция более новой сборки: пересчитать её этим кодом нельзя
+		resp["error"] = err.Error()
 	} else {
 		// пересчёт dataHash и bitsHash for regular simulation tx
 		gp := paramsFromTx(tx)
-		_, digest := runSimulation(tx.Seed, gp)
+		digest := simulationDigest(tx.Seed, gp)
 		// dh2 должен быть SHA256 от path-digest, чтобы совпадать с tx.DataHash
 		dh2 := sha256.Sum256(digest[:])
 		bits := expandBitsFromPathDigest(digest, tx.Count, gp.Whiten)
@@ -540,16 +676,25 @@ func txVerify(w http.ResponseWriter, r *http.Request, id string) {
 		bits2 := hex.EncodeToString(hb.Sum(nil))
 		resp["data_hash_match"] = hex.EncodeToString(dh2[:]) == tx.DataHash
 		resp["bits_hash_match"] = bits2 == tx.BitsHash
+		resp["prng"] = simPRNGName(gp.PRNG)
 	}
-	// проверим в блоке
-	chainMutex.RLock()
-	for i := range chain {
-		if chain[i].TxID == id {
-			resp["published_in_chain"] = (chain[i].DataHash == tx.Published)
-			break
+	if tx.TxSignature != "" {
+		txMutex.RLock()
+		resp["tx_signature_valid"] = keys.verifyTx(tx)
+		txMutex.RUnlock()
+	}
+	// проверим в блоке; у commit–reveal розыгрыша два блока: commitment и результат
+	txMutex.RLock()
+	published := tx.Published
+	txMutex.RUnlock()
+	if blk, found, err := findTxBlock(id, published, chainLen()); err != nil {
+		log.Printf("txVerify: %v", err)
+	} else if found {
+		resp["published_in_chain"] = true
+		if blk.Signature != "" {
+			resp["block_signature_valid"] = keys.verifyBlock(blk)
 		}
 	}
-	chainMutex.RUnlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(resp)
@@ -569,7 +714,33 @@ func txInfo(w http.ResponseWriter, r *http.Request, id string) {
 	if len(gp.Entropy.HTTP) > 0 {
 		q = append(q, fmt.Sprintf("http=%s", strings.Join(gp.Entropy.HTTP, ",")))
 	}
+	if gp.Entropy.Beacon != "" {
+		q = append(q, fmt.Sprintf("beacon=%s&round=%d", gp.Entropy.Beacon, gp.Entropy.Round))
+	}
 	q = append(q, fmt.Sprintf("law=%s", gp.Motion.Law))
+	params := make([]string, 0, len(gp.Motion.Params))
+	for k := range gp.Motion.Params {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		q = append(q, fmt.Sprintf("%s=%g", k, gp.Motion.Params[k]))
+	}
+	if gp.Motion.Collide {
+		q = append(q, fmt.Sprintf("collide=1&radius=%g", gp.Motion.CollideRadius))
+	}
+	if gp.Motion.Attract != 0 {
+		q = append(q, fmt.Sprintf("attract=%g&attract_range=%g", gp.Motion.Attract, gp.Motion.AttractRange))
+	}
+	if gp.Motion.Flock != 0 {
+		q = append(q, fmt.Sprintf("flock=%g&flock_range=%g", gp.Motion.Flock, gp.Motion.FlockRange))
+	}
+	if c := gp.Chaos; c != nil && c.Gate.MinLyapunov != nil {
+		q = append(q, fmt.Sprintf("min_lyapunov=%g", *c.Gate.MinLyapunov))
+	}
+	if c := gp.Chaos; c != nil && c.Gate.MinVisitEntropy != nil {
+		q = append(q, fmt.Sprintf("min_visit_entropy=%g", *c.Gate.MinVisitEntropy))
+	}
 	q = append(q, fmt.Sprintf("iter=%d", gp.Iterations))
 	q = append(q, fmt.Sprintf("points=%d", gp.NumPoints))
 	q = append(q, fmt.Sprintf("w=%d", gp.CanvasW))
@@ -577,22 +748,44 @@ func txInfo(w http.ResponseWriter, r *http.Request, id string) {
 	q = append(q, fmt.Sprintf("px=%d", gp.PixelWidth))
 	q = append(q, fmt.Sprintf("step=%g", gp.Step))
 	q = append(q, fmt.Sprintf("whiten=%s", gp.Whiten))
-	replayURL := "/generate?" + strings.Join(q, "&")
-
-	out := map[string]any{
-		"tx":         tx,
-		"replay_url": replayURL,
+	if gp.DRBG != "" {
+		q = append(q, fmt.Sprintf("drbg=%s", gp.DRBG))
+	}
+	out := map[string]any{"tx": tx}
+	if simulated(tx) && gp.PRNG != simPRNGCurrent {
+		// /generate всегда берёт текущий генератор симуляции: такой URL дал
+		// бы другой digest, проверять транзакцию — через /tx/{id}/verify
+		out["replay_note"] = "simulated with prng " + simPRNGName(gp.PRNG) + ", which /generate no longer uses; check it with /tx/" + id + "/verify"
+		out["verify_url"] = "/tx/" + id + "/verify"
+	} else {
+		out["replay_url"] = "/generate?" + strings.Join(q, "&")
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(out)
 }
 
-// /txs - return a list of transaction summaries (omit large SimulationData)
+// /txs[?cursor=<tx id>&limit=N] - return a list of transaction summaries
+// (omit large SimulationData) in tx id order. Without limit — all of them;
+// with it the next page's cursor is in the X-Next-Cursor header.
 func txsHandler(w http.ResponseWriter, r *http.Request) {
-	// return list of transactions (snapshot under lock)
+	cursor := r.URL.Query().Get("cursor")
+	limit := 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			http.Error(w, "bad limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	txs, next, err := store.ListTx(cursor, limit)
+	if err != nil {
+		http.Error(w, "store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := make([]map[string]any, 0, len(txs))
 	txMutex.RLock()
-	list := make([]map[string]any, 0, len(txStore))
-	for _, tx := range txStore {
+	for _, tx := range txs {
 		item := map[string]any{
 			"tx_id":      tx.TxID,
 			"created_at": tx.CreatedAt.Format(time.RFC3339),
@@ -606,6 +799,9 @@ func txsHandler(w http.ResponseWriter, r *http.Request) {
 		list = append(list, item)
 	}
 	txMutex.RUnlock()
+	if next != "" {
+		w.Header().Set("X-Next-Cursor", next)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(list)
 }
@@ -615,8 +811,12 @@ func txReproduce(w http.ResponseWriter, r *http.Request, id string) {
 	if tx == nil {
 		return
 	}
+	if err := checkSimulationModes(&tx.Provenance); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	gp := paramsFromTx(tx)
-	_, digest := runSimulation(tx.Seed, gp)
+	digest := simulationDigest(tx.Seed, gp)
 	bits := expandBitsFromPathDigest(digest, tx.Count, gp.Whiten)
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.reproduce.txt\"", id))
@@ -639,6 +839,10 @@ func txTRNG(w http.ResponseWriter, r *http.Request, id string) {
 	if tx == nil {
 		return
 	}
+	if err := checkSimulationModes(&tx.Provenance); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	q := r.URL.Query()
 	// n is number of bits; default to tx.Count (stored in bits)
 	nBits := atoi(q.Get("n"), -1)
@@ -654,9 +858,9 @@ func txTRNG(w http.ResponseWriter, r *http.Request, id string) {
 	// Reconstruct bits from the stored simulation (chaotic movement) so
 	// the output reflects the simulation-derived TRNG rather than the
 	// HMAC-DRBG stream. This follows the same pipeline used at
-	// generation: runSimulation -> expandBitsFromPathDigest.
+	// generation: simulationDigest -> expandBitsFromPathDigest.
 	gp := paramsFromTx(tx)
-	_, digest := runSimulation(tx.Seed, gp)
+	digest := simulationDigest(tx.Seed, gp)
 	bits := expandBitsFromPathDigest(digest, nBits, gp.Whiten)
 
 	// Pack bits (0/1 bytes) into bytes MSB-first per byte
@@ -739,13 +943,15 @@ func txTRNG(w http.ResponseWriter, r *http.Request, id string) {
 }
 
 func mustTx(id string, w http.ResponseWriter) *Transaction {
-	txMutex.RLock()
-	tx, ok := txStore[id]
-	txMutex.RUnlock()
-	if !ok {
+	tx, err := store.GetTx(id)
+	if errors.Is(err, errTxNotFound) {
 		http.Error(w, "tx not found", http.StatusNotFound)
 		return nil
 	}
+	if err != nil {
+		http.Error(w, "store: "+err.Error(), http.StatusInternalServerError)
+		return nil
+	}
 	return tx
 }
 
@@ -761,5 +967,7 @@ func paramsFromTx(tx *Transaction) GenerateParams {
 		Entropy:    tx.Provenance.Entropy,
 		Motion:     tx.Provenance.Motion,
 		Whiten:     tx.Provenance.Whiten,
+		DRBG:       tx.Provenance.DRBG,
+		PRNG:       tx.Provenance.PRNG,
 	}
 }
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Публичный маяк случайности в формате drand HTTP API:
+//   GET {base}/info           -> {"period", "genesis_time", "hash", "public_key", ...}
+//   GET {base}/public/{round} -> {"round", "randomness", "signature", "previous_signature"}
+//   GET {base}/public/latest
+// и те же пути с префиксом /{chain hash} для конкретной цепочки.
+// У каждого раунда проверяется randomness = SHA-256(signature), как в drand.
+// Розыгрыш при commit фиксирует hash, public_key и схему цепочки
+// (DrawCommit), а reveal и verify-draw проверяют BLS-подпись раунда этим
+// ключом (beaconbls.go): подделать раунд не может и тот, кто отвечает по URL
+// маяка, поэтому verify-draw принимает и свой URL маяка. Что зафиксирован
+// ключ настоящей цепочки drand, а не заглушки оператора, участники сверяют
+// с опубликованными hash и public_key цепочки до дедлайна. Локальная
+// заглушка для тестов — tools/beacon_stub.go.
+
+// BeaconRound is one round as served by the beacon.
+type BeaconRound struct {
+	Round             uint64 `json:"round"`
+	Randomness        string `json:"randomness"`
+	Signature         string `json:"signature"`
+	PreviousSignature string `json:"previous_signature,omitempty"`
+}
+
+type beaconInfo struct {
+	Period      int    `json:"period"`       // seconds
+	GenesisTime int64  `json:"genesis_time"` // unix seconds
+	Hash        string `json:"hash,omitempty"`
+	PublicKey   string `json:"public_key,omitempty"`
+	SchemeID    string `json:"schemeID,omitempty"` // "" in drand v1: pedersen-bls-chained
+}
+
+// errBeaconPending: the requested round has not been published yet.
+var errBeaconPending = errors.New("beacon round not published yet")
+
+// roundAt is the latest round published at or before t (0 before genesis).
+func (bi beaconInfo) roundAt(t time.Time) uint64 {
+	if bi.Period <= 0 || t.Unix() < bi.GenesisTime {
+		return 0
+	}
+	return uint64((t.Unix()-bi.GenesisTime)/int64(bi.Period)) + 1
+}
+
+// roundTime is when round r is published.
+func (bi beaconInfo) roundTime(r uint64) time.Time {
+	return time.Unix(bi.GenesisTime+int64(r-1)*int64(bi.Period), 0).UTC()
+}
+
+func beaconGet(base, path string, v any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(base, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errBeaconPending
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("beacon %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(v)
+}
+
+func fetchBeaconInfo(base string) (beaconInfo, error) {
+	return fetchChainInfo(base, "")
+}
+
+// fetchChainInfo reads /{chainHash}/info and checks the beacon reports that
+// hash; "" is the beacon's default chain.
+func fetchChainInfo(base, chainHash string) (beaconInfo, error) {
+	var bi beaconInfo
+	if err := beaconGet(base, chainPrefix(chainHash)+"/info", &bi); err != nil {
+		if errors.Is(err, errBeaconPending) {
+			return bi, fmt.Errorf("beacon does not serve chain %q", chainHash)
+		}
+		return bi, err
+	}
+	if bi.Period <= 0 {
+		return bi, errors.New("beacon info: bad period")
+	}
+	if chainHash != "" && bi.Hash != chainHash {
+		return bi, fmt.Errorf("beacon serves chain %q, want %q", bi.Hash, chainHash)
+	}
+	return bi, nil
+}
+
+func chainPrefix(chainHash string) string {
+	if chainHash == "" {
+		return ""
+	}
+	return "/" + chainHash
+}
+
+// validChainHash: drand chain hashes are hex SHA-256; anything else would
+// also end up in a URL path.
+func validChainHash(h string) bool {
+	b, err := hex.DecodeString(h)
+	return err == nil && len(b) == sha256.Size && h == strings.ToLower(h)
+}
+
+// fetchBeaconRound returns round r (0 = latest) of the default chain and
+// its decoded randomness.
+func fetchBeaconRound(base string, r uint64) (BeaconRound, []byte, error) {
+	return fetchChainRound(base, "", r)
+}
+
+// fetchDrawBeacon fetches the round a draw agreed on, from the chain pinned
+// at commit, and checks its BLS signature with the pinned public key. base
+// overrides the stored beacon URL ("" keeps it): the signature, not the
+// server, vouches for the round. Commits made before pinning have no chain
+// and no key; their rounds only get the randomness = SHA-256(signature) check.
+func fetchDrawBeacon(d *DrawCommit, base string) ([]byte, error) {
+	if base == "" {
+		base = d.Beacon
+	}
+	br, rnd, err := fetchChainRound(base, d.BeaconChainHash, d.BeaconRound)
+	if err != nil || d.BeaconPublicKey == "" {
+		return rnd, err
+	}
+	if err := verifyBeaconRound(d.BeaconScheme, d.BeaconPublicKey, br); err != nil {
+		return nil, err
+	}
+	return rnd, nil
+}
+
+func fetchChainRound(base, chainHash string, r uint64) (BeaconRound, []byte, error) {
+	path := "/public/latest"
+	if r != 0 {
+		path = "/public/" + strconv.FormatUint(r, 10)
+	}
+	var br BeaconRound
+	if err := beaconGet(base, chainPrefix(chainHash)+path, &br); err != nil {
+		if errors.Is(err, errBeaconPending) {
+			return br, nil, fmt.Errorf("round %d: %w", r, err)
+		}
+		return br, nil, err
+	}
+	if r != 0 && br.Round != r {
+		return br, nil, fmt.Errorf("beacon returned round %d, asked for %d", br.Round, r)
+	}
+	rnd, err := hex.DecodeString(br.Randomness)
+	if err != nil || len(rnd) != sha256.Size {
+		return br, nil, errors.New("beacon: bad randomness")
+	}
+	sig, err := hex.DecodeString(br.Signature)
+	if err != nil {
+		return br, nil, errors.New("beacon: bad signature encoding")
+	}
+	if h := sha256.Sum256(sig); hex.EncodeToString(h[:]) != br.Randomness {
+		return br, nil, errors.New("beacon: randomness != sha256(signature)")
+	}
+	return br, rnd, nil
+}
+
+// beaconSeed: первые 8 байт randomness, little-endian, как и для http-тел.
+func beaconSeed(randomness []byte) int64 {
+	return int64(binary.LittleEndian.Uint64(randomness[:8]))
+}
+
+// beacon: seed берётся из раунда маяка (es.Round, 0 — последний). Значение
+// публичное, поэтому min-entropy не заявляется: режим даёт
+// непредсказуемость заранее и воспроизводимость, а не секретность.
+type beaconSource struct{}
+
+func (beaconSource) Name() string        { return "beacon" }
+func (beaconSource) MinEntropy() float64 { return 0 }
+func (beaconSource) Raw(es EntropySpec, n int) ([]byte, error) {
+	if es.Beacon == "" {
+		return nil, errors.New("beacon: no beacon URL")
+	}
+	_, rnd, err := fetchBeaconRound(es.Beacon, es.Round)
+	if err != nil {
+		return nil, err
+	}
+	return rnd[:min(n, len(rnd))], nil
+}
+func (beaconSource) Record(es EntropySpec) (EntropyRecord, error) {
+	if es.Beacon == "" {
+		return EntropyRecord{}, errors.New("beacon: no beacon URL")
+	}
+	br, rnd, err := fetchBeaconRound(es.Beacon, es.Round)
+	if err != nil {
+		return EntropyRecord{}, err
+	}
+	return EntropyRecord{
+		Seed:        beaconSeed(rnd),
+		Tag:         "mode:beacon round=" + strconv.FormatUint(br.Round, 10),
+		BeaconRound: br.Round,
+	}, nil
+}
+
+// nextBeaconRound is the first round published strictly after t: the round
+// a draw agrees on at commit time so nobody can know it before the deadline.
+// chainHash "" takes the beacon's default chain; the returned info names the
+// chain to pin.
+func nextBeaconRound(base, chainHash string, t time.Time) (uint64, time.Time, beaconInfo, error) {
+	bi, err := fetchChainInfo(base, chainHash)
+	if err != nil {
+		return 0, time.Time{}, bi, err
+	}
+	if !validChainHash(bi.Hash) || bi.PublicKey == "" {
+		return 0, time.Time{}, bi, errors.New("beacon info: no chain hash or public key to pin")
+	}
+	if bi.SchemeID == "" {
+		bi.SchemeID = schemeChained
+	}
+	// reveal must be able to check the round, so an unknown scheme or a
+	// malformed key is refused now rather than after the deadline
+	if err := checkBeaconKey(bi.SchemeID, bi.PublicKey); err != nil {
+		return 0, time.Time{}, bi, err
+	}
+	r := bi.roundAt(t) + 1
+	return r, bi.roundTime(r), bi, nil
+}
@@ -3,54 +3,55 @@ package main
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/hmac"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
-)
 
-var (
-	txStore    = map[string]*Transaction{}
-	txMutex    sync.RWMutex
-	chain      = make([]Block, 0)
-	chainMutex sync.RWMutex
-	// in-memory signing key for tier signatures (HMAC-SHA256)
-	signingKey []byte
+	"golang.org/x/crypto/scrypt"
 )
 
-func appendBlock(tx *Transaction) {
-	chainMutex.Lock()
+// txMutex guards fields of *Transaction values: the json store hands out
+// the pointers it keeps, and handlers mutate them (signTx, reveal).
+var txMutex sync.RWMutex
+
+// appendBlock signs tx and stores it together with a new block on top of
+// the chain.
+func appendBlock(tx *Transaction) error {
+	signTx(tx)
+	appendMu.Lock()
+	defer appendMu.Unlock()
+	n, head, err := store.Head()
+	if err != nil {
+		return err
+	}
 	prev := ""
-	if len(chain) > 0 {
-		prev = chain[len(chain)-1].Hash
+	if n > 0 {
+		prev = head.Hash
 	}
 	blk := Block{
-		Index:     len(chain),
+		Index:     n,
 		Timestamp: time.Now().Unix(),
 		TxID:      tx.TxID,
 		DataHash:  tx.Published,
 		PrevHash:  prev,
 	}
 	blk.Hash = computeBlockHash(blk)
-	chain = append(chain, blk)
-	// unlock before persisting because saveStore acquires chainMutex.RLock
-	chainMutex.Unlock()
-
-	// persist store after new block appended
-	if err := saveStore(); err != nil {
-		// log but don't fail the HTTP request
-		log.Printf("failed to save store: %v", err)
-	} else {
-		log.Printf("appended block %d (tx=%s) and saved store", blk.Index, tx.TxID)
+	signBlock(&blk)
+	if err := store.AppendBlock(tx, blk); err != nil {
+		log.Printf("failed to store block %d (tx=%s): %v", blk.Index, tx.TxID, err)
+		return err
 	}
+	log.Printf("appended block %d (tx=%s)", blk.Index, tx.TxID)
+	return nil
 }
 
 func computeBlockHash(b Block) string {
@@ -60,17 +61,17 @@ func computeBlockHash(b Block) string {
 }
 
 func validateChain() bool {
-	chainMutex.RLock()
-	defer chainMutex.RUnlock()
-	for i := range chain {
-		if computeBlockHash(chain[i]) != chain[i].Hash {
+	ok := true
+	var prev *Block
+	err := scanBlocks(0, chainLen(), func(b Block) bool {
+		if computeBlockHash(b) != b.Hash || (prev != nil && b.PrevHash != prev.Hash) {
+			ok = false
 			return false
 		}
-		if i > 0 && chain[i].PrevHash != chain[i-1].Hash {
-			return false
-		}
-	}
-	return true
+		prev = &b
+		return true
+	})
+	return ok && err == nil
 }
 
 func newUUID() string {
@@ -86,32 +87,26 @@ func newUUID() string {
 		b[10:16])
 }
 
-// initSigningKey ensures we have an in-memory signing key; if nil, generate one.
-func initSigningKey() {
-	if len(signingKey) == 0 {
-		k := make([]byte, 32)
-		_, _ = rand.Read(k)
-		signingKey = k
-	}
-}
-
-// signTierPayload computes HMAC-SHA256 over provided payload
-func signTierPayload(payload []byte) string {
-	if len(signingKey) == 0 {
-		initSigningKey()
-	}
-	mac := hmac.New(sha256.New, signingKey)
-	mac.Write(payload)
-	return fmt.Sprintf("%x", mac.Sum(nil))
-}
-
-// persistence
+// persistence of the json backend: store.json is the compacted snapshot,
+// newer records are in the WAL (wal.go) starting at segment WALSegment
 type persistedStore struct {
 	TxStore map[string]*Transaction `json:"tx_store"`
 	Chain   []Block                 `json:"chain"`
-	// signing key stored as hex; if SIGNING_KEY_PASSPHRASE set at runtime then the value
-	// will be AES-GCM encrypted hex (nonce + ciphertext) and should be decrypted on load.
+	// signing keys (signer.go); secrets are stored as hex, or if SIGNING_KEY_PASSPHRASE
+	// is set at runtime as AES-GCM encrypted hex (nonce + ciphertext), see sealKey.
+	Keyring []persistedKey `json:"keyring,omitempty"`
+	// older stores: single HMAC key and single Ed25519 key, same encoding;
+	// read once and moved into Keyring
 	SigningKey string `json:"signing_key,omitempty"`
+	Ed25519Key string `json:"ed25519_key,omitempty"`
+	// first WAL segment not covered by this snapshot; 0 in stores written
+	// before the WAL, which are imported as the initial snapshot
+	WALSegment uint64 `json:"wal_segment,omitempty"`
+}
+
+type persistedKey struct {
+	signingKeyEntry
+	Secret string `json:"secret"`
 }
 
 func storePath() string {
@@ -120,125 +115,272 @@ func storePath() string {
 	return filepath.Join(cwd, "store.json")
 }
 
-func saveStore() error {
-	// copy under locks
-	txMutex.RLock()
-	copyTx := make(map[string]*Transaction, len(txStore))
-	for k, v := range txStore {
-		// shallow copy the transaction value but sanitize the Simulation to avoid
-		// marshalling potentially very large simulation paths into store.json
-		tmp := *v
-		tmp.Sim = SimulationData{}
-		copyTx[k] = &tmp
-	}
-	txMutex.RUnlock()
-
-	chainMutex.RLock()
-	copyChain := make([]Block, len(chain))
-	copy(copyChain, chain)
-	chainMutex.RUnlock()
-
-	// persist signing key: encode as hex or encrypted hex if passphrase provided
-	skHex := ""
-	if len(signingKey) > 0 {
-		pass := os.Getenv("SIGNING_KEY_PASSPHRASE")
-		if pass != "" {
-			if enc, err := encryptWithPassphrase(signingKey, pass); err == nil {
-				skHex = enc
-			} else {
-				// fallback to raw hex if encryption fails
-				skHex = hex.EncodeToString(signingKey)
-			}
-		} else {
-			skHex = hex.EncodeToString(signingKey)
+func sealedKeyring() []persistedKey {
+	signerMu.RLock()
+	defer signerMu.RUnlock()
+	keys := make([]persistedKey, len(keyring))
+	for i, k := range keyring {
+		sec := k.sealed
+		if len(k.secret) > 0 {
+			sec = sealKey(k.secret)
 		}
+		keys[i] = persistedKey{signingKeyEntry: *k, Secret: sec}
 	}
-	p := persistedStore{TxStore: copyTx, Chain: copyChain, SigningKey: skHex}
-	data, err := json.MarshalIndent(p, "", "  ")
+	return keys
+}
+
+// writeFileSync is tmp + fsync + rename + fsync of the directory.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
 	}
-	tmp := storePath() + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+	if _, err := f.Write(data); err != nil {
+		f.Close()
 		return err
 	}
-	if err := os.Rename(tmp, storePath()); err != nil {
+	if err := f.Sync(); err != nil {
+		f.Close()
 		return err
 	}
-	log.Printf("store persisted to %s", storePath())
-	return nil
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
 }
 
-func loadStore() error {
-	path := storePath()
-	b, err := os.ReadFile(path)
+// loadKeyring restores the keyring from s, migrating what restoreKeyring
+// migrates, and writes it back if anything changed.
+func loadKeyring(s Store) error {
+	keys, err := s.Keyring()
 	if err != nil {
 		return err
 	}
-	var p persistedStore
-	if err := json.Unmarshal(b, &p); err != nil {
-		// backup corrupt store and return an error so the caller knows load failed
-		ts := time.Now().Format("20060102-150405")
-		bad := path + ".corrupt-" + ts
-		if err2 := os.Rename(path, bad); err2 == nil {
-			log.Printf("loadStore: moved invalid store.json to %s due to parse error: %v", bad, err)
+	p := persistedStore{Keyring: keys}
+	if ls, ok := s.(legacyKeyStore); ok {
+		p.SigningKey, p.Ed25519Key = ls.legacyKeys()
+	}
+	if restoreKeyring(p) {
+		return s.PutKeyring(sealedKeyring())
+	}
+	return nil
+}
+
+// persistKeyring stores the keyring after a change.
+func persistKeyring() {
+	if store == nil {
+		return
+	}
+	if err := store.PutKeyring(sealedKeyring()); err != nil {
+		log.Printf("failed to persist keyring: %v", err)
+	}
+}
+
+// restoreKeyring loads the keyring, migrating the single-key fields of older
+// stores: the HMAC key becomes a retired "hmac-sha256" entry and the Ed25519
+// key the first active one. Reports whether anything must be re-saved
+// (migrated fields or stale envelopes, see openKey).
+func restoreKeyring(p persistedStore) (migrated bool) {
+	var ks keySet
+	for _, pk := range p.Keyring {
+		k := pk.signingKeyEntry
+		sec, stale, err := openKey(pk.Secret)
+		migrated = migrated || stale
+		if err == nil && !validKeySize(k.Alg, sec) {
+			err = fmt.Errorf("%d-byte %s key", len(sec), k.Alg)
+		}
+		if err != nil {
+			// keep it sealed: the public key still verifies, and the next
+			// PutKeyring must not drop a key that only needs the right passphrase
+			log.Printf("failed to restore key %s from store (wrong SIGNING_KEY_PASSPHRASE?): %v", k.ID, err)
+			k.sealed = pk.Secret
 		} else {
-			log.Printf("loadStore: failed to move invalid store.json: %v (parse error: %v)", err2, err)
-			// return the original parse error if we couldn't move the file
-			return err
+			k.secret = sec
 		}
-		return fmt.Errorf("store.json invalid, moved to %s: %w", bad, err)
-	}
-	txMutex.Lock()
-	txStore = p.TxStore
-	txMutex.Unlock()
-	chainMutex.Lock()
-	chain = p.Chain
-	chainMutex.Unlock()
-
-	// restore signing key if present
-	if p.SigningKey != "" {
-		pass := os.Getenv("SIGNING_KEY_PASSPHRASE")
-		var sk []byte
-		var err error
-		if pass != "" {
-			// try decrypt with passphrase
-			sk, err = decryptWithPassphrase(p.SigningKey, pass)
-			if err != nil {
-				// fallback: try raw hex decode
-				if b, err2 := hex.DecodeString(p.SigningKey); err2 == nil {
-					sk = b
-					err = nil
-				}
+		ks = append(ks, &k)
+	}
+	if len(p.Keyring) == 0 && (p.SigningKey != "" || p.Ed25519Key != "") {
+		migrated = true
+		now := time.Now().UTC()
+		if p.SigningKey != "" {
+			if sk, _, err := openKey(p.SigningKey); err == nil && validKeySize(keyAlgHMAC, sk) {
+				ks = append(ks, &signingKeyEntry{ID: keyIDFor(keyAlgHMAC, sk), Alg: keyAlgHMAC, ActivatedAt: now, RetiredAt: &now, secret: sk})
+				log.Printf("restored HMAC signing key from store")
+			} else {
+				log.Printf("failed to restore signing key from store: %v", err)
 			}
-		} else {
-			sk, err = hex.DecodeString(p.SigningKey)
 		}
-		if err == nil && len(sk) > 0 {
-			signingKey = sk
-			log.Printf("restored signing key from store")
-		} else {
-			log.Printf("failed to restore signing key from store: %v", err)
+		if p.Ed25519Key != "" {
+			if k, _, err := openKey(p.Ed25519Key); err == nil && validKeySize(sigAlgEd25519, k) {
+				ks = append(ks, newEd25519Entry(ed25519.PrivateKey(k), now))
+				log.Printf("restored ed25519 key from store")
+			} else {
+				log.Printf("failed to restore ed25519 key from store: %v", err)
+			}
 		}
 	}
-	log.Printf("loaded store: %d transactions, %d blocks", len(txStore), len(chain))
-	return nil
+	signerMu.Lock()
+	keyring = ks
+	signerMu.Unlock()
+	return migrated
+}
+
+// sealKey encodes a key for store.json: hex, or an encrypted envelope if
+// SIGNING_KEY_PASSPHRASE is set. Empty key -> "".
+func sealKey(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	if pass := os.Getenv("SIGNING_KEY_PASSPHRASE"); pass != "" {
+		if enc, err := encryptWithPassphrase(raw, pass); err == nil {
+			return enc
+		}
+		// fallback to raw hex if encryption fails
+	}
+	return hex.EncodeToString(raw)
+}
+
+// validKeySize: a 32-byte HMAC key or a 64-byte Ed25519 private key.
+func validKeySize(alg string, key []byte) bool {
+	switch alg {
+	case keyAlgHMAC:
+		return len(key) == hmacKeySize
+	case sigAlgEd25519:
+		return len(key) == ed25519.PrivateKeySize
+	}
+	return false
+}
+
+// openKey reverses sealKey; with a passphrase set it still accepts raw hex.
+// stale reports an envelope that loadKeyring should re-seal (raw hex
+// under a passphrase, or the legacy SHA-256 envelope). A legacy envelope
+// hex-decodes too, so raw hex is only taken if it has a key's length —
+// otherwise a wrong passphrase would re-seal the ciphertext as the key.
+func openKey(s string) (key []byte, stale bool, err error) {
+	pass := os.Getenv("SIGNING_KEY_PASSPHRASE")
+	if pass == "" {
+		key, err = hex.DecodeString(s)
+		return key, false, err
+	}
+	key, err = decryptWithPassphrase(s, pass)
+	if err != nil {
+		// fallback: try raw hex decode
+		if b, err2 := hex.DecodeString(s); err2 == nil && (validKeySize(keyAlgHMAC, b) || validKeySize(sigAlgEd25519, b)) {
+			return b, true, nil
+		}
+		return nil, false, err
+	}
+	return key, !strings.HasPrefix(s, envelopeV2+"$"), nil
+}
+
+// Конверт для ключей, зашифрованных пассфразой:
+//
+//	v2$scrypt$N=32768,r=8,p=1$<salt hex>$<hex(nonce|ciphertext)>
+//
+// AES-256-GCM, ключ = scrypt(passphrase, salt, N, r, p, 32), заголовок (всё до
+// последнего "$") идёт в GCM как additional data. Старый формат — просто
+// hex(nonce|ciphertext) с ключом SHA256(passphrase); он читается, а при
+// загрузке перезаписывается в v2 (loadKeyring).
+const (
+	envelopeV2   = "v2"
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	envelopeSalt = 16
+	maxScryptN   = 1 << 20
+	maxScryptRP  = 1 << 6
+	aesKeyLen    = 32
+)
+
+// scrypt — это ~0.1 с и 32 MiB на вызов, а каждый снимок и каждая запись
+// связки в WAL шифруют все её ключи. Поэтому процесс шифрует с одной солью, а
+// выведенные ключи кэшируются по (заголовок, пассфраза).
+var (
+	kdfMu    sync.Mutex
+	kdfSalt  []byte
+	kdfCache = map[string][]byte{}
+)
+
+type kdfParams struct {
+	N, R, P int
+}
+
+func (kp kdfParams) String() string {
+	return fmt.Sprintf("N=%d,r=%d,p=%d", kp.N, kp.R, kp.P)
+}
+
+func parseKDFParams(s string) (kdfParams, error) {
+	var kp kdfParams
+	if _, err := fmt.Sscanf(s, "N=%d,r=%d,p=%d", &kp.N, &kp.R, &kp.P); err != nil {
+		return kp, fmt.Errorf("envelope: bad scrypt params %q", s)
+	}
+	if kp.N < 2 || kp.N > maxScryptN || kp.N&(kp.N-1) != 0 || kp.R < 1 || kp.R > maxScryptRP || kp.P < 1 || kp.P > maxScryptRP {
+		return kp, fmt.Errorf("envelope: scrypt params out of range %q", s)
+	}
+	return kp, nil
+}
+
+// deriveKeyFromPassphrase: scrypt(passphrase, salt), cached per header.
+func deriveKeyFromPassphrase(pass string, salt []byte, kp kdfParams, header string) ([]byte, error) {
+	kdfMu.Lock()
+	defer kdfMu.Unlock()
+	ck := header + "\x00" + pass
+	if k, ok := kdfCache[ck]; ok {
+		return k, nil
+	}
+	k, err := scrypt.Key([]byte(pass), salt, kp.N, kp.R, kp.P, aesKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	kdfCache[ck] = k
+	return k, nil
 }
 
-// deriveKeyFromPassphrase: SHA256(passphrase)
-func deriveKeyFromPassphrase(pass string) []byte {
+// legacyKeyFromPassphrase: SHA256(passphrase), only for reading old envelopes
+func legacyKeyFromPassphrase(pass string) []byte {
 	h := sha256.Sum256([]byte(pass))
 	return h[:]
 }
 
-// encryptWithPassphrase encrypts raw bytes with AES-GCM and returns hex(nonce|ciphertext)
-func encryptWithPassphrase(raw []byte, pass string) (string, error) {
-	key := deriveKeyFromPassphrase(pass)
+func sealSalt() ([]byte, error) {
+	kdfMu.Lock()
+	defer kdfMu.Unlock()
+	if kdfSalt == nil {
+		s := make([]byte, envelopeSalt)
+		if _, err := rand.Read(s); err != nil {
+			return nil, err
+		}
+		kdfSalt = s
+	}
+	return kdfSalt, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWithPassphrase encrypts raw bytes with AES-GCM under a scrypt key
+// and returns a v2 envelope.
+func encryptWithPassphrase(raw []byte, pass string) (string, error) {
+	salt, err := sealSalt()
 	if err != nil {
 		return "", err
 	}
-	g, err := cipher.NewGCM(block)
+	kp := kdfParams{N: scryptN, R: scryptR, P: scryptP}
+	header := strings.Join([]string{envelopeV2, "scrypt", kp.String(), hex.EncodeToString(salt)}, "$")
+	key, err := deriveKeyFromPassphrase(pass, salt, kp, header)
+	if err != nil {
+		return "", err
+	}
+	g, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
@@ -246,23 +388,40 @@ func encryptWithPassphrase(raw []byte, pass string) (string, error) {
 	if _, err := rand.Read(nonce); err != nil {
 		return "", err
 	}
-	ct := g.Seal(nil, nonce, raw, nil)
+	ct := g.Seal(nil, nonce, raw, []byte(header))
 	out := append(nonce, ct...)
-	return hex.EncodeToString(out), nil
+	return header + "$" + hex.EncodeToString(out), nil
 }
 
-// decryptWithPassphrase decodes hex(nonce|ciphertext) and decrypts with AES-GCM
-func decryptWithPassphrase(hexIn string, pass string) ([]byte, error) {
-	data, err := hex.DecodeString(hexIn)
-	if err != nil {
-		return nil, err
+// decryptWithPassphrase opens a v2 envelope or a legacy hex(nonce|ciphertext).
+func decryptWithPassphrase(in string, pass string) ([]byte, error) {
+	var key []byte
+	header, body := "", in // legacy: no header, SHA-256 key
+	if i := strings.LastIndexByte(in, '$'); i >= 0 {
+		header, body = in[:i], in[i+1:]
+		parts := strings.Split(header, "$")
+		if len(parts) != 4 || parts[0] != envelopeV2 || parts[1] != "scrypt" {
+			return nil, fmt.Errorf("envelope: unsupported format %q", header)
+		}
+		kp, err := parseKDFParams(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		salt, err := hex.DecodeString(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("envelope: bad salt")
+		}
+		if key, err = deriveKeyFromPassphrase(pass, salt, kp, header); err != nil {
+			return nil, err
+		}
+	} else {
+		key = legacyKeyFromPassphrase(pass)
 	}
-	key := deriveKeyFromPassphrase(pass)
-	block, err := aes.NewCipher(key)
+	data, err := hex.DecodeString(body)
 	if err != nil {
 		return nil, err
 	}
-	g, err := cipher.NewGCM(block)
+	g, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
@@ -272,9 +431,5 @@ func decryptWithPassphrase(hexIn string, pass string) ([]byte, error) {
 	}
 	nonce := data[:ns]
 	ct := data[ns:]
-	pt, err := g.Open(nil, nonce, ct, nil)
-	if err != nil {
-		return nil, err
-	}
-	return pt, nil
+	return g.Open(nil, nonce, ct, []byte(header))
 }
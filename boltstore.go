@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore keeps everything in one bbolt file (STORE_BACKEND=bolt):
+// bucket "tx" maps tx id to JSON, "blocks" maps the big-endian uint64
+// index to JSON, "meta" holds the keyring. Nothing but bbolt's page cache
+// stays in memory, so it scales to millions of transactions. Every Update
+// is fsynced by bbolt.
+type boltStore struct {
+	db *bolt.DB
+}
+
+var (
+	boltTxBucket     = []byte("tx")
+	boltBlocksBucket = []byte("blocks")
+	boltMetaBucket   = []byte("meta")
+	boltKeyringKey   = []byte("keyring")
+)
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(btx *bolt.Tx) error {
+		for _, b := range [][]byte{boltTxBucket, boltBlocksBucket, boltMetaBucket} {
+			if _, err := btx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func boltIndexKey(i int) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], uint64(i))
+	return k[:]
+}
+
+func putBoltTx(btx *bolt.Tx, tx *Transaction) error {
+	c := storedTx(tx)
+	v, err := json.Marshal(&c)
+	if err != nil {
+		return err
+	}
+	return btx.Bucket(boltTxBucket).Put([]byte(c.TxID), v)
+}
+
+func (s *boltStore) PutTx(tx *Transaction) error {
+	return s.db.Update(func(btx *bolt.Tx) error { return putBoltTx(btx, tx) })
+}
+
+func (s *boltStore) GetTx(id string) (*Transaction, error) {
+	var tx Transaction
+	err := s.db.View(func(btx *bolt.Tx) error {
+		v := btx.Bucket(boltTxBucket).Get([]byte(id))
+		if v == nil {
+			return errTxNotFound
+		}
+		return json.Unmarshal(v, &tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func (s *boltStore) ListTx(cursor string, limit int) ([]*Transaction, string, error) {
+	var out []*Transaction
+	next := ""
+	err := s.db.View(func(btx *bolt.Tx) error {
+		c := btx.Bucket(boltTxBucket).Cursor()
+		k, v := c.Seek([]byte(cursor))
+		if k != nil && bytes.Equal(k, []byte(cursor)) {
+			k, v = c.Next()
+		}
+		for ; k != nil; k, v = c.Next() {
+			if limit > 0 && len(out) == limit {
+				next = out[len(out)-1].TxID
+				break
+			}
+			var tx Transaction
+			if err := json.Unmarshal(v, &tx); err != nil {
+				return err
+			}
+			out = append(out, &tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return out, next, nil
+}
+
+func (s *boltStore) AppendBlock(tx *Transaction, b Block) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		blocks := btx.Bucket(boltBlocksBucket)
+		n := 0
+		if k, _ := blocks.Cursor().Last(); k != nil {
+			n = int(binary.BigEndian.Uint64(k)) + 1
+		}
+		if b.Index != n {
+			return errBlockConflict
+		}
+		if err := putBoltTx(btx, tx); err != nil {
+			return err
+		}
+		v, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		return blocks.Put(boltIndexKey(b.Index), v)
+	})
+}
+
+func (s *boltStore) Blocks(from, to int) ([]Block, error) {
+	var out []Block
+	from = max(from, 0)
+	err := s.db.View(func(btx *bolt.Tx) error {
+		c := btx.Bucket(boltBlocksBucket).Cursor()
+		for k, v := c.Seek(boltIndexKey(from)); k != nil && int(binary.BigEndian.Uint64(k)) < to; k, v = c.Next() {
+			var b Block
+			if err := json.Unmarshal(v, &b); err != nil {
+				return err
+			}
+			out = append(out, b)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (s *boltStore) Head() (int, Block, error) {
+	var head Block
+	n := 0
+	err := s.db.View(func(btx *bolt.Tx) error {
+		k, v := btx.Bucket(boltBlocksBucket).Cursor().Last()
+		if k == nil {
+			return nil
+		}
+		n = int(binary.BigEndian.Uint64(k)) + 1
+		return json.Unmarshal(v, &head)
+	})
+	return n, head, err
+}
+
+func (s *boltStore) Keyring() ([]persistedKey, error) {
+	var keys []persistedKey
+	err := s.db.View(func(btx *bolt.Tx) error {
+		v := btx.Bucket(boltMetaBucket).Get(boltKeyringKey)
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &keys)
+	})
+	return keys, err
+}
+
+func (s *boltStore) PutKeyring(keys []persistedKey) error {
+	v, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(btx *bolt.Tx) error {
+		return btx.Bucket(boltMetaBucket).Put(boltKeyringKey, v)
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// Диагностика хаоса симуляции: /tx/{id}/chaos и порог в /generate.
+//
+//   - старший показатель Ляпунова — рядом с прогоном идёт близнец с тем же
+//     seed (те же выборы законов и случайные рывки), точки которого сдвинуты
+//     на δ0 в пространстве (x, y, vx, vy). Каждые chaosRenorm тиков
+//     расстояние d между ними добавляет ln(d/δ0), а близнец возвращается на
+//     δ0 вдоль того же направления (Бенеттин). Если близнец слился с
+//     прогоном (d == 0: оба упёрлись в стенку или maxV) или расстояние не
+//     число, интервал пропускается — он не входит ни в сумму, ни в число
+//     тиков, на которое она делится: ln(0) это артефакт float, а не
+//     сходимость траекторий, и одно такое слияние перевесило бы весь прогон
+//     (ln(SmallestNonzeroFloat64/δ0) ≈ -731). Внутреннее состояние законов
+//     (маятник, аттрактор) у близнеца не возмущается — меряется
+//     чувствительность самих точек;
+//   - дисперсия смещения за тик для каждой точки (Уэлфорд): ноль у застрявшей
+//     точки и у точки с постоянной скоростью;
+//   - энтропия посещений сетки chaosGrid×chaosGrid, нормированная в [0,1];
+//   - периодическая орбита — алгоритм Брента по полному состоянию точек
+//     с допуском chaosPeriodTol.
+//
+// Диагностика не трогает pathDigest: транзакция проверяется как раньше.
+
+const (
+	chaosDelta0      = 1e-6
+	chaosRenorm      = 10
+	chaosGrid        = 32
+	chaosPeriodTol   = 1e-3
+	chaosMaxIter     = 20_000
+	chaosMaxPerPoint = 1000
+)
+
+type ChaosReport struct {
+	Iterations int `json:"iterations"`
+	// на тик и на единицу времени симуляции (тик = Step)
+	Lyapunov        float64 `json:"lyapunov"`
+	LyapunovPerTime float64 `json:"lyapunov_per_time"`
+	// интервалы перенормировки, где близнец слился с прогоном; в показатель не входят
+	SkippedIntervals int     `json:"skipped_intervals,omitempty"`
+	MeanVariance     float64 `json:"mean_displacement_variance"`
+	MinVariance      float64 `json:"min_displacement_variance"`
+	// по точкам, если их не больше chaosMaxPerPoint
+	PointVariance []float64 `json:"point_variance,omitempty"`
+	VisitEntropy  float64   `json:"visit_entropy"`
+	Periodic      bool      `json:"periodic"`
+	Period        int       `json:"period,omitempty"`
+	PeriodTick    int       `json:"period_tick,omitempty"`
+}
+
+// chaosDiagnostics прогоняет iters тиков симуляции (seed, gp) вместе с
+// возмущённым близнецом.
+func chaosDiagnostics(seed int64, gp GenerateParams, iters int) ChaosReport {
+	gp.Iterations = iters
+	workers := simWorkers(gp.NumPoints)
+	ref := newSimRun(seed, gp, false, workers)
+	defer ref.close()
+	twin := newSimRun(seed, gp, false, workers)
+	defer twin.close()
+	n := len(ref.pts)
+	rep := ChaosReport{Iterations: iters}
+	if n == 0 || iters <= 0 {
+		return rep
+	}
+	perturbTwin(ref.pts, twin.pts)
+
+	buf := make([]byte, 16*n)
+	tbuf := make([]byte, 16*n)
+	prev := make([]XY, n)
+	mean := make([]XY, n)
+	m2 := make([]XY, n)
+	visits := make([]int, chaosGrid*chaosGrid)
+	saved := snapshotState(ref.pts, nil)
+	power, lam := 1, 0
+	var lnSum float64
+	lnTicks, lastRenorm := 0, 0
+
+	for t := 0; t < iters; t++ {
+		for i, p := range ref.pts {
+			prev[i] = XY{p.x, p.y}
+		}
+		ref.tick(t, buf)
+		twin.tick(t, tbuf)
+
+		k := float64(t + 1)
+		for i, p := range ref.pts {
+			dx, dy := p.x-prev[i].X, p.y-prev[i].Y
+			ox, oy := dx-mean[i].X, dy-mean[i].Y
+			mean[i].X += ox / k
+			mean[i].Y += oy / k
+			m2[i].X += ox * (dx - mean[i].X)
+			m2[i].Y += oy * (dy - mean[i].Y)
+			visits[visitCell(p.x, p.y, gp.CanvasW, gp.CanvasH)]++
+		}
+
+		if !rep.Periodic {
+			lam++
+			if stateWithin(saved, ref.pts, chaosPeriodTol) {
+				rep.Periodic, rep.Period, rep.PeriodTick = true, lam, t
+			} else if lam == power {
+				saved = snapshotState(ref.pts, saved)
+				power *= 2
+				lam = 0
+			}
+		}
+
+		if (t+1)%chaosRenorm == 0 || t == iters-1 {
+			d := stateDistance(ref.pts, twin.pts)
+			switch {
+			case d == 0 || math.IsNaN(d) || math.IsInf(d, 0):
+				// близнец слился с прогоном: интервал пропускаем
+				rep.SkippedIntervals++
+				perturbTwin(ref.pts, twin.pts)
+			default:
+				lnSum += math.Log(d / chaosDelta0)
+				lnTicks += t + 1 - lastRenorm
+				rescaleTwin(ref.pts, twin.pts, chaosDelta0/d)
+			}
+			lastRenorm = t + 1
+		}
+	}
+
+	if lnTicks > 0 {
+		rep.Lyapunov = lnSum / float64(lnTicks)
+	}
+	step := gp.Step
+	if step <= 0 {
+		step = 0.01
+	}
+	rep.LyapunovPerTime = rep.Lyapunov / step
+
+	rep.MinVariance = math.Inf(1)
+	vars := make([]float64, n)
+	for i := range vars {
+		vars[i] = (m2[i].X + m2[i].Y) / float64(iters)
+		rep.MeanVariance += vars[i] / float64(n)
+		rep.MinVariance = min(rep.MinVariance, vars[i])
+	}
+	if n <= chaosMaxPerPoint {
+		rep.PointVariance = vars
+	}
+
+	total := float64(iters * n)
+	for _, c := range visits {
+		if c > 0 {
+			p := float64(c) / total
+			rep.VisitEntropy -= p * math.Log(p)
+		}
+	}
+	rep.VisitEntropy /= math.Log(chaosGrid * chaosGrid)
+	return rep
+}
+
+// perturbTwin ставит близнеца на расстояние chaosDelta0 от прогона: все
+// координаты и скорости сдвинуты на одну и ту же величину.
+func perturbTwin(ref, twin []*mover) {
+	eps := chaosDelta0 / math.Sqrt(float64(4*len(ref)))
+	for i, p := range ref {
+		q := twin[i]
+		q.x, q.y = p.x+eps, p.y+eps
+		q.vx, q.vy = p.vx+eps, p.vy+eps
+	}
+}
+
+func rescaleTwin(ref, twin []*mover, k float64) {
+	for i, p := range ref {
+		q := twin[i]
+		q.x = p.x + (q.x-p.x)*k
+		q.y = p.y + (q.y-p.y)*k
+		q.vx = p.vx + (q.vx-p.vx)*k
+		q.vy = p.vy + (q.vy-p.vy)*k
+	}
+}
+
+func stateDistance(ref, twin []*mover) float64 {
+	var s float64
+	for i, p := range ref {
+		q := twin[i]
+		dx, dy := q.x-p.x, q.y-p.y
+		dvx, dvy := q.vx-p.vx, q.vy-p.vy
+		s += dx*dx + dy*dy + dvx*dvx + dvy*dvy
+	}
+	return math.Sqrt(s)
+}
+
+func snapshotState(pts []*mover, dst []float64) []float64 {
+	dst = dst[:0]
+	for _, p := range pts {
+		dst = append(dst, p.x, p.y, p.vx, p.vy)
+	}
+	return dst
+}
+
+func stateWithin(saved []float64, pts []*mover, tol float64) bool {
+	for i, p := range pts {
+		s := saved[4*i:]
+		if !(math.Abs(p.x-s[0]) < tol && math.Abs(p.y-s[1]) < tol &&
+			math.Abs(p.vx-s[2]) < tol && math.Abs(p.vy-s[3]) < tol) {
+			return false
+		}
+	}
+	return true
+}
+
+// visitCell — ячейка сетки посещений; NaN и выход за холст — в крайние.
+func visitCell(x, y float64, w, h int) int {
+	fx := x / float64(w) * chaosGrid
+	fy := y / float64(h) * chaosGrid
+	if !(fx >= 0) {
+		fx = 0
+	}
+	if !(fy >= 0) {
+		fy = 0
+	}
+	cx := int(min(fx, chaosGrid-1))
+	cy := int(min(fy, chaosGrid-1))
+	return cy*chaosGrid + cx
+}
+
+// chaosIters — сколько тиков диагностировать: не больше chaosMaxIter.
+func chaosIters(gp GenerateParams) int {
+	return min(gp.Iterations, chaosMaxIter)
+}
+
+// chaosGate — пороги для /generate: ?min_lyapunov= и ?min_visit_entropy=,
+// по умолчанию из CHAOS_MIN_LYAPUNOV и CHAOS_MIN_VISIT_ENTROPY. Если задан
+// хоть один, параметры с меньшими значениями или периодической орбитой
+// отклоняются.
+type chaosGate struct {
+	MinLyapunov     *float64 `json:"min_lyapunov,omitempty"`
+	MinVisitEntropy *float64 `json:"min_visit_entropy,omitempty"`
+}
+
+func chaosGateFrom(q url.Values) (chaosGate, error) {
+	var g chaosGate
+	for _, f := range []struct {
+		param, env string
+		dst        **float64
+	}{
+		{"min_lyapunov", "CHAOS_MIN_LYAPUNOV", &g.MinLyapunov},
+		{"min_visit_entropy", "CHAOS_MIN_VISIT_ENTROPY", &g.MinVisitEntropy},
+	} {
+		if s := q.Get(f.param); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				return g, fmt.Errorf("bad %s: %q", f.param, s)
+			}
+			*f.dst = &v
+		} else if s := os.Getenv(f.env); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+				log.Printf("chaos: bad %s %q, ignoring", f.env, s)
+				continue
+			}
+			*f.dst = &v
+		}
+	}
+	return g, nil
+}
+
+func (g chaosGate) enabled() bool { return g.MinLyapunov != nil || g.MinVisitEntropy != nil }
+
+// check возвращает причину отказа или nil.
+func (g chaosGate) check(rep ChaosReport) error {
+	if rep.Periodic {
+		return fmt.Errorf("periodic orbit (period %d ticks)", rep.Period)
+	}
+	if g.MinLyapunov != nil && rep.Lyapunov < *g.MinLyapunov {
+		return fmt.Errorf("lyapunov %.4g < %g", rep.Lyapunov, *g.MinLyapunov)
+	}
+	if g.MinVisitEntropy != nil && rep.VisitEntropy < *g.MinVisitEntropy {
+		return fmt.Errorf("visit entropy %.4g < %g", rep.VisitEntropy, *g.MinVisitEntropy)
+	}
+	return nil
+}
+
+// ChaosSummary — итог проверки порогов, пишется в provenance транзакции.
+type ChaosSummary struct {
+	Iterations   int       `json:"iterations"`
+	Lyapunov     float64   `json:"lyapunov"`
+	VisitEntropy float64   `json:"visit_entropy"`
+	Gate         chaosGate `json:"gate"`
+}
+
+// /tx/{id}/chaos?iter=N — диагностика первых N тиков (по умолчанию и не
+// больше min(iterations, chaosMaxIter)).
+func txChaos(w http.ResponseWriter, r *http.Request, id string) {
+	tx := mustTx(id, w)
+	if tx == nil {
+		return
+	}
+	if !simulated(tx) {
+		http.Error(w, "tx has no simulation", http.StatusUnprocessableEntity)
+		return
+	}
+	if err := checkSimulationModes(&tx.Provenance); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	gp := paramsFromTx(tx)
+	limit := chaosIters(gp)
+	iters := atoi(r.URL.Query().Get("iter"), limit)
+	if iters <= 0 || iters > limit {
+		http.Error(w, fmt.Sprintf("iter must be in [1, %d]", limit), http.StatusBadRequest)
+		return
+	}
+	rep := chaosDiagnostics(tx.Seed, gp, iters)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+)
+
+// CTR_DRBG по SP 800-90A §10.2.1: AES-256, с derivation function
+// (Block_Cipher_df), счётчик — весь блок V.
+type CTRDRBG struct {
+	block cipher.Block
+	V     [aes.BlockSize]byte
+
+	reseedCounter uint64
+}
+
+const (
+	ctrKeyLen  = 32
+	ctrSeedLen = ctrKeyLen + aes.BlockSize // 384 bits
+)
+
+func newCTRDRBG(entropy, nonce, personalization []byte) *CTRDRBG {
+	d := &CTRDRBG{reseedCounter: 1}
+	d.setKey(make([]byte, ctrKeyLen))
+	d.update(ctrDF(concatBytes(entropy, nonce, personalization), ctrSeedLen))
+	return d
+}
+
+func (d *CTRDRBG) setKey(key []byte) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // key length is fixed at 32
+	}
+	d.block = b
+}
+
+// incV: V = (V + 1) mod 2^128.
+func (d *CTRDRBG) incV() {
+	for i := len(d.V) - 1; i >= 0; i-- {
+		d.V[i]++
+		if d.V[i] != 0 {
+			return
+		}
+	}
+}
+
+// update: CTR_DRBG_Update(provided_data, Key, V); provided is seedlen bytes.
+func (d *CTRDRBG) update(provided []byte) {
+	temp := make([]byte, 0, ctrSeedLen+aes.BlockSize)
+	var blk [aes.BlockSize]byte
+	for len(temp) < ctrSeedLen {
+		d.incV()
+		d.block.Encrypt(blk[:], d.V[:])
+		temp = append(temp, blk[:]...)
+	}
+	temp = temp[:ctrSeedLen]
+	for i := range temp {
+		temp[i] ^= provided[i]
+	}
+	d.setKey(temp[:ctrKeyLen])
+	copy(d.V[:], temp[ctrKeyLen:])
+}
+
+func (d *CTRDRBG) Reseed(entropy, additional []byte) error {
+	if err := checkDRBGInput(entropy, additional); err != nil {
+		return err
+	}
+	d.update(ctrDF(concatBytes(entropy, additional), ctrSeedLen))
+	d.reseedCounter = 1
+	return nil
+}
+
+func (d *CTRDRBG) Generate(n int, additional []byte) ([]byte, error) {
+	if err := checkGenerate(n, additional, d.reseedCounter); err != nil {
+		return nil, err
+	}
+	add := make([]byte, ctrSeedLen)
+	if len(additional) > 0 {
+		add = ctrDF(additional, ctrSeedLen)
+		d.update(add)
+	}
+	out := make([]byte, 0, n+aes.BlockSize)
+	var blk [aes.BlockSize]byte
+	for len(out) < n {
+		d.incV()
+		d.block.Encrypt(blk[:], d.V[:])
+		out = append(out, blk[:]...)
+	}
+	d.update(add)
+	d.reseedCounter++
+	return out[:n], nil
+}
+
+// ctrDF is Block_Cipher_df (§10.3.2) with AES-256, returning n bytes.
+func ctrDF(input []byte, n int) []byte {
+	// S = L || N || input || 0x80, дополнено нулями до кратного блоку
+	s := make([]byte, 8, 8+len(input)+aes.BlockSize)
+	binary.BigEndian.PutUint32(s[0:4], uint32(len(input)))
+	binary.BigEndian.PutUint32(s[4:8], uint32(n))
+	s = append(s, input...)
+	s = append(s, 0x80)
+	for len(s)%aes.BlockSize != 0 {
+		s = append(s, 0)
+	}
+
+	key := make([]byte, ctrKeyLen)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	k, _ := aes.NewCipher(key)
+	temp := make([]byte, 0, ctrSeedLen+aes.BlockSize)
+	var iv [aes.BlockSize]byte
+	for i := uint32(0); len(temp) < ctrSeedLen; i++ {
+		binary.BigEndian.PutUint32(iv[0:4], i)
+		temp = append(temp, bcc(k, iv[:], s)...)
+	}
+
+	k, _ = aes.NewCipher(temp[:ctrKeyLen])
+	x := make([]byte, aes.BlockSize)
+	copy(x, temp[ctrKeyLen:ctrSeedLen])
+	out := make([]byte, 0, n+aes.BlockSize)
+	for len(out) < n {
+		k.Encrypt(x, x)
+		out = append(out, x...)
+	}
+	return out[:n]
+}
+
+// bcc chains AES encryption over iv || data (both multiples of the block size).
+func bcc(k cipher.Block, iv, data []byte) []byte {
+	chain := make([]byte, aes.BlockSize)
+	for _, blocks := range [][]byte{iv, data} {
+		for off := 0; off < len(blocks); off += aes.BlockSize {
+			for i := 0; i < aes.BlockSize; i++ {
+				chain[i] ^= blocks[off+i]
+			}
+			k.Encrypt(chain, chain)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	mrand "math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Версии алгоритма розыгрыша tier. Пустая строка — исходный вариант с
+// Uint64 % (i+1): им сделаны все розыгрыши до появления draw_algorithm,
+// и только им их можно перепроверить.
+const (
+	drawAlgoLegacyMod = ""
+	drawAlgoLemireV1  = "lemire-v1"
+
+	// drawAlgoCurrent is what new draws use.
+	drawAlgoCurrent = drawAlgoLemireV1
+)
+
+var drawShuffles = map[string]func(src mrand.Source, n int, swap func(i, j int)){
+	drawAlgoLegacyMod: shuffleMod,
+	drawAlgoLemireV1:  shuffle,
+}
+
+// drawAlgoName is the display name; the legacy version has no stored name.
+func drawAlgoName(algo string) string {
+	if algo == drawAlgoLegacyMod {
+		return "legacy-mod"
+	}
+	return algo
+}
+
+// maxTierRange bounds max-min+1: drawTier shuffles the whole pool, so
+// memory and DRBG calls grow with the range (2^20 is ~8 MiB and a few s).
+const maxTierRange = 1 << 20
+
+func checkTierRange(min, max int) error {
+	switch {
+	case max < min:
+		return errors.New("max must be >= min")
+	case max-min < 0 || max-min >= maxTierRange: // max-min < 0: overflow
+		return fmt.Errorf("range must hold at most %d numbers", maxTierRange)
+	}
+	return nil
+}
+
+func validateTier(min, max, n, t int) error {
+	if err := checkTierRange(min, max); err != nil {
+		return err
+	}
+	switch {
+	case n <= 0:
+		return errors.New("n must be > 0")
+	case t <= 0 || t > n:
+		return errors.New("t must be >0 and <= n")
+	case n > max-min+1:
+		return errors.New("n must be <= (max-min+1)")
+	}
+	return nil
+}
+
+// drawTier: n уникальных чисел из [min,max] (Fisher–Yates по всему пулу),
+// затем t победителей среди них (перестановка индексов тем же shuffle).
+func drawTier(tr *TRNG, algo string, min, max, n, t int) (nums, winners []int, err error) {
+	shuf, ok := drawShuffles[algo]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown draw algorithm %q", algo)
+	}
+	if err := checkTierRange(min, max); err != nil {
+		return nil, nil, err
+	}
+	err = catchTRNG(func() {
+		pool := make([]int, 0, max-min+1)
+		for v := min; v <= max; v++ {
+			pool = append(pool, v)
+		}
+		shuf(tr, len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+		nums = append([]int(nil), pool[:n]...)
+
+		idxs := make([]int, n)
+		for i := range idxs {
+			idxs[i] = i
+		}
+		shuf(tr, n, func(i, j int) { idxs[i], idxs[j] = idxs[j], idxs[i] })
+		winners = make([]int, t)
+		for k := range winners {
+			winners[k] = nums[idxs[k]]
+		}
+	})
+	return nums, winners, err
+}
+
+// tierPayload is what the tier signature covers. Versioned draws also sign
+// the algorithm and range; legacy draws keep the original {seed, numbers, winners}.
+func tierPayload(tx *Transaction) []byte {
+	p := map[string]any{
+		"seed":    tx.Seed,
+		"numbers": tx.TierNumbers,
+		"winners": tx.TierWinners,
+	}
+	if tx.DrawAlgorithm != drawAlgoLegacyMod {
+		p["draw_algorithm"] = tx.DrawAlgorithm
+		p["tier_range"] = tx.TierRange
+	}
+	if tx.Draw != nil {
+		p["commitment"] = tx.Draw.Commitment
+	}
+	b, _ := json.Marshal(p)
+	return b
+}
+
+// /tx/{id}/verify-draw[?min=&max=] — повторяет розыгрыш из seed записанным
+// алгоритмом и сравнивает с сохранёнными числами. У старых транзакций
+// диапазон не записан: берётся из query, по умолчанию 1..49 как в /generate-tier.
+func txVerifyDraw(w http.ResponseWriter, r *http.Request, id string) {
+	tx := mustTx(id, w)
+	if tx == nil {
+		return
+	}
+	if len(tx.TierNumbers) == 0 {
+		http.Error(w, "not a tier transaction", http.StatusBadRequest)
+		return
+	}
+	out := map[string]any{
+		"tx_id":          tx.TxID,
+		"draw_algorithm": drawAlgoName(tx.DrawAlgorithm),
+	}
+	if tx.Provenance.PredictionResistance {
+		out["replayable"] = false
+		out["reason"] = "drawn with prediction resistance"
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
+		return
+	}
+
+	min, max, rangeFrom := 1, 49, "default"
+	if len(tx.TierRange) == 2 {
+		min, max, rangeFrom = tx.TierRange[0], tx.TierRange[1], "recorded"
+	} else if q := r.URL.Query(); q.Get("min") != "" || q.Get("max") != "" {
+		min, max, rangeFrom = atoi(q.Get("min"), 1), atoi(q.Get("max"), 49), "query"
+	}
+	n, t := len(tx.TierNumbers), len(tx.TierWinners)
+	if err := checkTierRange(min, max); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n > max-min+1 {
+		http.Error(w, "range does not fit the recorded numbers", http.StatusBadRequest)
+		return
+	}
+
+	tr, err := NewTRNGFromTx(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nums, winners, err := drawTier(tr, tx.DrawAlgorithm, min, max, n, t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out["replayable"] = true
+	out["min"], out["max"], out["range_from"] = min, max, rangeFrom
+	if tx.Draw != nil {
+		out["commitment_match"] = checkCommitment(tx) == nil
+		if per := tx.Provenance.PerHTTPSeeds; tx.Draw.BeaconRound != 0 && len(per) > 0 {
+			// раунд маяка перепроверяется по сети (?beacon= — свой URL маяка,
+			// раунд всё равно сверяется с зафиксированным ключом); ошибка — не повод падать
+			if rnd, err := fetchDrawBeacon(tx.Draw, r.URL.Query().Get("beacon")); err == nil {
+				out["beacon_match"] = beaconSeed(rnd) == per[len(per)-1]
+			} else {
+				out["beacon_error"] = err.Error()
+			}
+		}
+	}
+	out["numbers_match"] = equalInts(nums, tx.TierNumbers)
+	out["winners_match"] = equalInts(winners, tx.TierWinners)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ======= commit–reveal =======
+//
+// POST /draw/commit публикует блок с H(seed material || salt) и параметрами
+// розыгрыша до дедлайна приёма заявок; seed и salt хранятся отдельно от
+// store.json. POST /draw/{id}/reveal после дедлайна раскрывает их, проводит
+// розыгрыш через TRNG и публикует второй блок. Проверка: SHA-256 от
+// trngSeedMaterial(seed, per_http_seeds) || salt должен совпасть с
+// commitment, а розыгрыш повторяется через /tx/{id}/verify-draw.
+//
+// С полем beacon при commit фиксируется первый раунд маяка после дедлайна;
+// при reveal его значение (beaconSeed) добавляется последним per-seed.
+// Так результат не может предсказать никто, включая оператора, а после
+// reveal его может повторить любой.
+
+// drawSecret is what stays private between commit and reveal.
+type drawSecret struct {
+	Seed     int64   `json:"seed"`
+	PerSeeds []int64 `json:"per_seeds,omitempty"`
+	Tag      string  `json:"tag"`
+	Salt     string  `json:"salt"`
+}
+
+var drawSecretsMu sync.Mutex
+
+// drawCommitSources are the entropy modes a commit accepts: the outcome
+// depends only on seed and per-seeds, so with a public or caller-chosen seed
+// (repro, http, beacon) anyone could compute the winners before the deadline.
+var drawCommitSources = map[string]bool{"os": true, "jitter": true, "mix": true}
+
+func drawSecretsPath() string {
+	return filepath.Join(filepath.Dir(storePath()), "draw_secrets.json")
+}
+
+// loadDrawSecrets reads the secrets file; a missing file is an empty set.
+// Caller holds drawSecretsMu.
+func loadDrawSecrets() (map[string]drawSecret, error) {
+	m := map[string]drawSecret{}
+	b, err := os.ReadFile(drawSecretsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(b, &m)
+}
+
+// saveDrawSecrets writes the file owner-only, tmp+rename like store snapshots.
+// Caller holds drawSecretsMu.
+func saveDrawSecrets(m map[string]drawSecret) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := drawSecretsPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, drawSecretsPath())
+}
+
+func drawCommitment(seed int64, per []int64, salt []byte) string {
+	h := sha256.New()
+	h.Write(trngSeedMaterial(seed, per))
+	h.Write(salt)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// checkCommitment recomputes the commitment from the revealed seed and salt.
+func checkCommitment(tx *Transaction) error {
+	if tx.Draw == nil || tx.Draw.RevealedAt == nil {
+		return errors.New("draw not revealed")
+	}
+	salt, err := hex.DecodeString(tx.Draw.Salt)
+	if err != nil {
+		return err
+	}
+	per := tx.Provenance.PerHTTPSeeds
+	if tx.Draw.BeaconRound != 0 && len(per) > 0 {
+		per = per[:len(per)-1] // beacon value is not part of the commitment
+	}
+	if drawCommitment(tx.Seed, per, salt) != tx.Draw.Commitment {
+		return errors.New("commitment mismatch")
+	}
+	return nil
+}
+
+type drawCommitRequest struct {
+	Min      int         `json:"min"`
+	Max      int         `json:"max"`
+	N        int         `json:"n"`
+	T        int         `json:"t"`
+	Entropy  EntropySpec `json:"entropy"`
+	DRBG     string      `json:"drbg"`
+	Deadline time.Time   `json:"deadline"` // required, in the future; reveal is refused before it
+	Beacon   string      `json:"beacon"`   // optional drand-style URL; the first round after the deadline is mixed in
+	// optional: the chain the beacon must serve (drand chain hash); by
+	// default the beacon's default chain, pinned either way
+	BeaconChainHash string `json:"beacon_chain_hash"`
+}
+
+// POST /draw/commit  {"min":1,"max":49,"n":6,"t":1,"entropy":{"mode":"mix"},"deadline":"2026-01-01T00:00:00Z"}
+func drawCommitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireSelfTest(w) {
+		return
+	}
+	req := drawCommitRequest{Min: 1, Max: 49, N: 10, T: 1, Entropy: EntropySpec{Mode: "mix"}}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Entropy.Mode = strings.ToLower(req.Entropy.Mode)
+	req.DRBG = strings.ToLower(req.DRBG)
+	if err := validateTier(req.Min, req.Max, req.N, req.T); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !req.Deadline.After(time.Now()) {
+		http.Error(w, "deadline must be set and in the future", http.StatusBadRequest)
+		return
+	}
+	if _, ok := lookupEntropySource(req.Entropy.Mode); !ok || !drawCommitSources[req.Entropy.Mode] {
+		http.Error(w, "entropy mode can't be committed: "+req.Entropy.Mode+" (secret sources: os,jitter,mix)", http.StatusBadRequest)
+		return
+	}
+	if req.DRBG == "" {
+		req.DRBG = defaultDRBG
+	} else if _, ok := drbgMechanisms[req.DRBG]; !ok {
+		http.Error(w, "unknown drbg: "+req.DRBG+" (known: "+strings.Join(drbgNames(), ",")+")", http.StatusBadRequest)
+		return
+	}
+
+	ent, err := deriveSeed(req.Entropy)
+	if err != nil {
+		log.Printf("draw/commit: entropy error: %v", err)
+		http.Error(w, "entropy: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		http.Error(w, "salt: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	commitment := drawCommitment(ent.Seed, ent.PerSeeds, salt)
+
+	// seed, per-seeds и тег (в repro/mix он содержит seed) — только в секретах
+	spec := req.Entropy
+	spec.Seed64 = 0
+	tx := &Transaction{
+		TxID:      newUUID(),
+		CreatedAt: time.Now().UTC(),
+		Count:     req.N,
+		Published: commitment,
+		Provenance: GenerationProvenance{
+			Entropy:        spec,
+			HealthFailures: ent.HealthFailures,
+			DRBG:           req.DRBG,
+		},
+		TierRange:     []int{req.Min, req.Max},
+		DrawAlgorithm: drawAlgoCurrent,
+		Draw: &DrawCommit{
+			Commitment:  commitment,
+			T:           req.T,
+			CommittedAt: time.Now().UTC(),
+		},
+	}
+	deadline := req.Deadline.UTC()
+	tx.Draw.Deadline = &deadline
+	if req.Beacon != "" {
+		if req.BeaconChainHash != "" && !validChainHash(req.BeaconChainHash) {
+			http.Error(w, "beacon_chain_hash must be 64 lowercase hex chars", http.StatusBadRequest)
+			return
+		}
+		round, at, bi, err := nextBeaconRound(req.Beacon, req.BeaconChainHash, deadline)
+		if err != nil {
+			http.Error(w, "beacon: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		tx.Draw.Beacon, tx.Draw.BeaconRound, tx.Draw.BeaconRoundTime = req.Beacon, round, &at
+		tx.Draw.BeaconChainHash, tx.Draw.BeaconPublicKey, tx.Draw.BeaconScheme = bi.Hash, bi.PublicKey, bi.SchemeID
+		tx.Provenance.BeaconRound = round
+	}
+
+	drawSecretsMu.Lock()
+	secrets, err := loadDrawSecrets()
+	if err == nil {
+		secrets[tx.TxID] = drawSecret{Seed: ent.Seed, PerSeeds: ent.PerSeeds, Tag: ent.Tag, Salt: hex.EncodeToString(salt)}
+		err = saveDrawSecrets(secrets)
+	}
+	drawSecretsMu.Unlock()
+	if err != nil {
+		log.Printf("draw/commit: failed to persist secret: %v", err)
+		http.Error(w, "failed to persist draw secret", http.StatusInternalServerError)
+		return
+	}
+
+	if err := appendBlock(tx); err != nil {
+		http.Error(w, "store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("draw/commit: tx %s committed %s", tx.TxID, commitment)
+
+	resp := map[string]any{
+		"tx_id":      tx.TxID,
+		"commitment": commitment,
+		"deadline":   tx.Draw.Deadline,
+		"min":        req.Min,
+		"max":        req.Max,
+		"n":          req.N,
+		"t":          req.T,
+	}
+	if tx.Draw.BeaconRound != 0 {
+		resp["beacon_round"] = tx.Draw.BeaconRound
+		resp["beacon_round_time"] = tx.Draw.BeaconRoundTime
+		resp["beacon_chain_hash"] = tx.Draw.BeaconChainHash
+		resp["beacon_public_key"] = tx.Draw.BeaconPublicKey
+		resp["beacon_scheme"] = tx.Draw.BeaconScheme
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// drawRouter: GET /draw/{id}, POST /draw/{id}/reveal
+func drawRouter(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/draw/")
+	if p == "commit" {
+		drawCommitHandler(w, r)
+		return
+	}
+	id, action, _ := strings.Cut(p, "/")
+	if id == "" {
+		http.Error(w, "missing draw id", http.StatusBadRequest)
+		return
+	}
+	switch action {
+	case "":
+		drawInfo(w, r, id)
+	case "reveal":
+		drawReveal(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func mustDraw(id string, w http.ResponseWriter) *Transaction {
+	tx := mustTx(id, w)
+	if tx != nil && tx.Draw == nil {
+		http.Error(w, "not a commit-reveal draw", http.StatusBadRequest)
+		return nil
+	}
+	return tx
+}
+
+func drawInfo(w http.ResponseWriter, r *http.Request, id string) {
+	tx := mustDraw(id, w)
+	if tx == nil {
+		return
+	}
+	txMutex.RLock()
+	out := map[string]any{
+		"tx_id":    tx.TxID,
+		"draw":     tx.Draw,
+		"min":      tx.TierRange[0],
+		"max":      tx.TierRange[1],
+		"n":        tx.Count,
+		"revealed": tx.Draw.RevealedAt != nil,
+	}
+	if tx.Draw.RevealedAt != nil {
+		out["seed"] = tx.Seed
+		out["per_http_seeds"] = tx.Provenance.PerHTTPSeeds
+		out["numbers"] = tx.TierNumbers
+		out["winners"] = tx.TierWinners
+		out["signature"] = tx.Signature
+	}
+	txMutex.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func drawReveal(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireSelfTest(w) {
+		return
+	}
+	// один reveal на розыгрыш: секреты удаляются под тем же мьютексом, а tx
+	// читается уже под ним — bolt/log отдают копию, взятая до мьютекса была
+	// бы устаревшей и второй reveal не увидел бы RevealedAt
+	drawSecretsMu.Lock()
+	defer drawSecretsMu.Unlock()
+	tx := mustDraw(id, w)
+	if tx == nil {
+		return
+	}
+	if tx.Draw.RevealedAt != nil {
+		http.Error(w, "draw already revealed", http.StatusConflict)
+		return
+	}
+	if tx.Draw.Deadline != nil && time.Now().Before(*tx.Draw.Deadline) {
+		http.Error(w, "entry deadline not reached: "+tx.Draw.Deadline.Format(time.RFC3339), http.StatusConflict)
+		return
+	}
+	secrets, err := loadDrawSecrets()
+	if err != nil {
+		http.Error(w, "draw secrets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sec, ok := secrets[id]
+	if !ok {
+		http.Error(w, "draw secret not found", http.StatusInternalServerError)
+		return
+	}
+	salt, err := hex.DecodeString(sec.Salt)
+	if err != nil || drawCommitment(sec.Seed, sec.PerSeeds, salt) != tx.Draw.Commitment {
+		http.Error(w, "stored secret does not match the commitment", http.StatusInternalServerError)
+		return
+	}
+
+	per := sec.PerSeeds
+	if tx.Draw.BeaconRound != 0 {
+		rnd, err := fetchDrawBeacon(tx.Draw, "")
+		if errors.Is(err, errBeaconPending) {
+			http.Error(w, fmt.Sprintf("beacon round %d not published yet", tx.Draw.BeaconRound), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "beacon: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		per = append(append([]int64(nil), per...), beaconSeed(rnd))
+	}
+
+	tr, err := NewTRNG(tx.Provenance.DRBG, sec.Seed, per)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nums, winners, err := drawTier(tr, tx.DrawAlgorithm, tx.TierRange[0], tx.TierRange[1], tx.Count, tx.Draw.T)
+	if err != nil {
+		http.Error(w, "trng: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	now := time.Now().UTC()
+	txMutex.Lock()
+	tx.Seed = sec.Seed
+	tx.Provenance.PerHTTPSeeds = per
+	tx.Provenance.Entropy.Mode = sec.Tag
+	tx.TierNumbers, tx.TierWinners = nums, winners
+	// новый DrawCommit, а не правка на месте: снимок store.json маршалит копии tx вне мьютекса
+	d := *tx.Draw
+	d.Salt, d.RevealedAt = sec.Salt, &now
+	tx.Draw = &d
+	signTier(tx)
+	tx.Published = tx.Signature
+	txMutex.Unlock()
+	if err := appendBlock(tx); err != nil {
+		http.Error(w, "store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	delete(secrets, id)
+	if err := saveDrawSecrets(secrets); err != nil {
+		log.Printf("draw/reveal: failed to drop revealed secret: %v", err)
+	}
+	log.Printf("draw/reveal: tx %s revealed", id)
+
+	resp := map[string]any{
+		"tx_id":          tx.TxID,
+		"commitment":     tx.Draw.Commitment,
+		"seed":           tx.Seed,
+		"per_http_seeds": tx.Provenance.PerHTTPSeeds,
+		"salt":           tx.Draw.Salt,
+		"numbers":        nums,
+		"winners":        winners,
+		"signature":      tx.Signature,
+	}
+	if tx.Draw.BeaconRound != 0 {
+		resp["beacon_round"] = tx.Draw.BeaconRound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
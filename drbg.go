@@ -3,15 +3,145 @@ package main
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 )
 
-// Minimal HMAC-DRBG (SP800-90A style) using HMAC-SHA256.
-// Not fully featured (no reseed interval), but suitable for deterministic generation from seed material.
+// DRBG по SP 800-90A: HMAC_DRBG (здесь), CTR_DRBG (ctrdrbg.go) и
+// Hash_DRBG (hashdrbg.go), все с security strength 256.
+
+// HMAC_DRBG по SP 800-90A §10.1.2 (HMAC-SHA256).
+// Instantiate/Reseed/Generate следуют спецификации; newHMACDRBG и generate
+// оставлены для старого потока (whitening=hmac и сохранённые транзакции).
 type HMACDRBG struct {
 	K []byte
 	V []byte
+
+	reseedCounter uint64
+}
+
+const (
+	// drbgSecurityStrength in bytes; entropy input must be at least this long.
+	drbgSecurityStrength = 32
+	// drbgReseedInterval is the maximum number of Generate calls between reseeds (2^48).
+	drbgReseedInterval = 1 << 48
+	// drbgMaxRequestBytes is max_number_of_bits_per_request (2^19 bits).
+	drbgMaxRequestBytes = 1 << 16
+	// drbgMaxInputBytes caps entropy/nonce/personalization/additional input (2^35 bits).
+	drbgMaxInputBytes = 1 << 32
+)
+
+var (
+	// ErrReseedRequired is returned by Generate once the reseed counter
+	// exceeds drbgReseedInterval. Call Reseed and retry.
+	ErrReseedRequired = errors.New("drbg: reseed required")
+	// ErrRequestTooLarge is returned for Generate requests over drbgMaxRequestBytes.
+	ErrRequestTooLarge = fmt.Errorf("drbg: request exceeds %d bytes", drbgMaxRequestBytes)
+)
+
+// DRBG is an SP 800-90A mechanism after instantiation. All mechanisms here
+// run at security strength 256 and share the limits above.
+type DRBG interface {
+	Reseed(entropy, additional []byte) error
+	Generate(n int, additional []byte) ([]byte, error)
+}
+
+// Механизмы по имени (параметр drbg=). newDRBG* создают их без проверок
+// длины входа: seed транзакции (seed||perSeeds) короче security strength.
+var drbgMechanisms = map[string]func(entropy, nonce, personalization []byte) DRBG{
+	"hmac-sha256": func(e, n, p []byte) DRBG { return newHMACDRBG(concatBytes(e, n, p)) },
+	"ctr-aes256":  func(e, n, p []byte) DRBG { return newCTRDRBG(e, n, p) },
+	"hash-sha256": func(e, n, p []byte) DRBG { return newHashDRBG(sha256.New, e, n, p) },
+	"hash-sha512": func(e, n, p []byte) DRBG { return newHashDRBG(sha512.New, e, n, p) },
+}
+
+// defaultDRBG is what new transactions record when drbg= is not given.
+// Provenance without a drbg field (older transactions) means the legacy
+// hmac-sha256 stream, see TRNG.legacy.
+const defaultDRBG = "hmac-sha256"
+
+func drbgNames() []string {
+	names := make([]string, 0, len(drbgMechanisms))
+	for n := range drbgMechanisms {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InstantiateDRBG creates the named mechanism with SP 800-90A input checks.
+func InstantiateDRBG(mech string, entropy, nonce, personalization []byte) (DRBG, error) {
+	if mech == "" {
+		mech = defaultDRBG
+	}
+	mk, ok := drbgMechanisms[mech]
+	if !ok {
+		return nil, fmt.Errorf("drbg: unknown mechanism %q (known: %s)", mech, strings.Join(drbgNames(), ","))
+	}
+	if err := checkDRBGInput(entropy, nonce, personalization); err != nil {
+		return nil, err
+	}
+	return mk(entropy, nonce, personalization), nil
+}
+
+// Instantiate creates an HMAC_DRBG from entropy input, nonce and an optional
+// personalization string: seed_material = entropy || nonce || personalization.
+func Instantiate(entropy, nonce, personalization []byte) (*HMACDRBG, error) {
+	if err := checkDRBGInput(entropy, nonce, personalization); err != nil {
+		return nil, err
+	}
+	return newHMACDRBG(concatBytes(entropy, nonce, personalization)), nil
 }
 
+// checkDRBGInput: entropy covers the security strength, the rest of the
+// inputs (nonce/personalization or additional input) stay under the cap.
+func checkDRBGInput(entropy []byte, rest ...[]byte) error {
+	if len(entropy) < drbgSecurityStrength {
+		return fmt.Errorf("drbg: entropy input too short (%d < %d bytes)", len(entropy), drbgSecurityStrength)
+	}
+	if len(entropy) > drbgMaxInputBytes {
+		return errors.New("drbg: input too long")
+	}
+	for _, b := range rest {
+		if len(b) > drbgMaxInputBytes {
+			return errors.New("drbg: input too long")
+		}
+	}
+	return nil
+}
+
+// checkGenerate validates a Generate request against the shared limits.
+func checkGenerate(n int, additional []byte, reseedCounter uint64) error {
+	if n < 0 || n > drbgMaxRequestBytes {
+		return ErrRequestTooLarge
+	}
+	if len(additional) > drbgMaxInputBytes {
+		return errors.New("drbg: additional input too long")
+	}
+	if reseedCounter > drbgReseedInterval {
+		return ErrReseedRequired
+	}
+	return nil
+}
+
+func concatBytes(parts ...[]byte) []byte {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	out := make([]byte, 0, n)
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// newHMACDRBG instantiates without input length checks. Legacy seed material
+// (seed||perSeeds, 8 bytes per value) is shorter than the security strength,
+// so TRNG and whitening keep using this entry point.
 func newHMACDRBG(seedMaterial []byte) *HMACDRBG {
 	// initialize K = 0x00.., V = 0x01..
 	K := make([]byte, 32)
@@ -19,7 +149,7 @@ func newHMACDRBG(seedMaterial []byte) *HMACDRBG {
 	for i := range V {
 		V[i] = 0x01
 	}
-	d := &HMACDRBG{K: K, V: V}
+	d := &HMACDRBG{K: K, V: V, reseedCounter: 1}
 	d.update(seedMaterial)
 	return d
 }
@@ -58,16 +188,39 @@ func (d *HMACDRBG) update(seedMaterial []byte) {
 	}
 }
 
-// Generate n bytes
-func (d *HMACDRBG) Generate(n int) []byte {
-	out := make([]byte, 0, n)
+// Reseed mixes fresh entropy and optional additional input into the state
+// and resets the reseed counter.
+func (d *HMACDRBG) Reseed(entropy, additional []byte) error {
+	if err := checkDRBGInput(entropy, additional); err != nil {
+		return err
+	}
+	d.update(concatBytes(entropy, additional))
+	d.reseedCounter = 1
+	return nil
+}
+
+// Generate returns n bytes. At most drbgMaxRequestBytes per call; once the
+// reseed interval is exhausted every call fails with ErrReseedRequired.
+func (d *HMACDRBG) Generate(n int, additional []byte) ([]byte, error) {
+	if err := checkGenerate(n, additional, d.reseedCounter); err != nil {
+		return nil, err
+	}
+	if len(additional) > 0 {
+		d.update(additional)
+	}
+	out := d.generate(n, additional)
+	d.reseedCounter++
+	return out, nil
+}
+
+// generate is the unchecked core of Generate: no request limit and no
+// counter. The legacy whitening stream draws its whole output in one call.
+func (d *HMACDRBG) generate(n int, additional []byte) []byte {
+	out := make([]byte, 0, n+32)
 	for len(out) < n {
-		mac := hmac.New(sha256.New, d.K)
-		mac.Write(d.V)
-		d.V = mac.Sum(nil)
+		d.V = d.hmac(d.V)
 		out = append(out, d.V...)
 	}
-	// per spec, update with no additional input
-	d.update(nil)
+	d.update(additional)
 	return out[:n]
 }
@@ -6,91 +6,272 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"math"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// EntropySource is a named provider of raw entropy. deriveSeed resolves
+// EntropySpec.Mode through the registry below, so a new source only has to
+// be registered instead of being wired into a switch.
+type EntropySource interface {
+	// Name is the mode accepted in EntropySpec.Mode (and by ?entropy=).
+	Name() string
+	// Raw returns up to n bytes of unconditioned source output for es.
+	Raw(es EntropySpec, n int) ([]byte, error)
+	// MinEntropy is the claimed min-entropy of Raw output, bits per byte.
+	MinEntropy() float64
+	// Record derives the master seed and the provenance written into the tx.
+	Record(es EntropySpec) (EntropyRecord, error)
+}
+
+// EntropyRecord is what a source leaves in GenerationProvenance: the master
+// seed, the human-readable tag stored in Entropy.Mode, per-HTTP seeds and any
+// health-test failures of sub-sources that were excluded from the result.
+type EntropyRecord struct {
+	Seed           int64
+	Tag            string
+	PerSeeds       []int64
+	HealthFailures []string
+	BeaconRound    uint64 // beacon source only
+}
+
+var entropySources = map[string]EntropySource{}
+
+// registerEntropySource adds src to the registry; sources register from init.
+func registerEntropySource(src EntropySource) {
+	entropySources[src.Name()] = src
+}
+
+func lookupEntropySource(mode string) (EntropySource, bool) {
+	src, ok := entropySources[mode]
+	return src, ok
+}
+
+// entropySourceNames returns registered modes in sorted order (for errors/help).
+func entropySourceNames() []string {
+	names := make([]string, 0, len(entropySources))
+	for n := range entropySources {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	registerEntropySource(reproSource{})
+	registerEntropySource(osSource{})
+	registerEntropySource(jitterSource{})
+	registerEntropySource(httpSource{})
+	registerEntropySource(mixSource{})
+	registerEntropySource(beaconSource{})
+}
+
+// errNoHealthySource is returned when every sub-source failed its health tests.
+var errNoHealthySource = errors.New("no healthy entropy source left")
+
 // deriveSeed: собирает сырьё по EntropySpec и выдаёт мастер-seed (int64) + тег-строку
 // Воспроизводимость гарантируется: при mode=repro используем Seed64;
 // для прочих режимов возвращаем сгенерированный seed и сохраняем его в транзакции.
-func deriveSeed(es EntropySpec) (seed int64, tag string, perSeeds []int64) {
-	switch es.Mode {
-	case "repro":
-		return es.Seed64, "mode:repro seed=" + itoa64(es.Seed64), nil
-	case "os":
-		return seedFromOS(), "mode:os", nil
-	case "jitter":
-		return seedFromJitter(32), "mode:jitter", nil
-	case "http":
-		return seedFromHTTP(es.HTTP), "mode:http:" + hexOfURLs(es.HTTP), nil
-	case "mix":
-		raw := make([][]byte, 0, 4)
-		raw = append(raw, rawFromOS(32))
-		raw = append(raw, rawFromJitter(48))
-		perSeedsStr := make([]string, 0, len(es.HTTP))
-		perSeeds = make([]int64, 0, len(es.HTTP))
-		if len(es.HTTP) > 0 {
-			// for each URL, fetch independently and include its raw into mix
-			for _, u := range es.HTTP {
-				b := rawFromHTTP([]string{u})
-				raw = append(raw, b)
-				var s int64
-				if len(b) >= 8 {
-					s = int64(binary.LittleEndian.Uint64(b[:8]))
-				} else {
-					// fallback: mix with OS bytes
-					extra := rawFromOS(8)
-					buf := append(b, extra...)
-					s = int64(binary.LittleEndian.Uint64(buf[:8]))
-				}
-				perSeeds = append(perSeeds, s)
-				perSeedsStr = append(perSeedsStr, itoa64(s))
-			}
-		}
-		h := sha256.New()
-		for _, b := range raw {
-			h.Write(b)
+func deriveSeed(es EntropySpec) (EntropyRecord, error) {
+	src, ok := lookupEntropySource(es.Mode)
+	if !ok {
+		return EntropyRecord{}, fmt.Errorf("unknown entropy mode %q (known: %s)", es.Mode, strings.Join(entropySourceNames(), ","))
+	}
+	return src.Record(es)
+}
+
+// repro: seed задаётся явно (Seed64), источника энтропии нет.
+type reproSource struct{}
+
+func (reproSource) Name() string        { return "repro" }
+func (reproSource) MinEntropy() float64 { return 0 }
+func (reproSource) Raw(es EntropySpec, n int) ([]byte, error) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(es.Seed64))
+	return b[:min(n, 8)], nil
+}
+func (reproSource) Record(es EntropySpec) (EntropyRecord, error) {
+	return EntropyRecord{Seed: es.Seed64, Tag: "mode:repro seed=" + itoa64(es.Seed64)}, nil
+}
+
+// os: системный крипто-PRNG.
+type osSource struct{}
+
+func (osSource) Name() string        { return "os" }
+func (osSource) MinEntropy() float64 { return 8 }
+func (osSource) Raw(es EntropySpec, n int) ([]byte, error) {
+	return rawFromOS(n)
+}
+func (osSource) Record(es EntropySpec) (EntropyRecord, error) {
+	seed, err := seedFromOS()
+	return EntropyRecord{Seed: seed, Tag: "mode:os"}, err
+}
+
+// jitter: тайминги планировщика/CPU. Заявка консервативная — 1 бит на сэмпл.
+type jitterSource struct{}
+
+func (jitterSource) Name() string        { return "jitter" }
+func (jitterSource) MinEntropy() float64 { return 1 }
+func (jitterSource) Raw(es EntropySpec, n int) ([]byte, error) {
+	return jitterSamples(n)
+}
+func (jitterSource) Record(es EntropySpec) (EntropyRecord, error) {
+	seed, err := seedFromJitter(32)
+	return EntropyRecord{Seed: seed, Tag: "mode:jitter"}, err
+}
+
+// http: тела ответов внешних URL. Они видны третьим лицам, поэтому
+// секретной энтропии мы за ними не заявляем.
+type httpSource struct{}
+
+func (httpSource) Name() string        { return "http" }
+func (httpSource) MinEntropy() float64 { return 0 }
+func (httpSource) Raw(es EntropySpec, n int) ([]byte, error) {
+	b, err := rawFromHTTP(es.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	return b[:min(n, len(b))], nil
+}
+func (httpSource) Record(es EntropySpec) (EntropyRecord, error) {
+	seed, err := seedFromHTTP(es.HTTP)
+	return EntropyRecord{Seed: seed, Tag: "mode:http:" + hexOfURLs(es.HTTP)}, err
+}
+
+// mix: OS + jitter + каждый HTTP URL по отдельности, всё через SHA256.
+// Sub-sources that fail a health test are left out of the mix and listed in
+// EntropyRecord.HealthFailures; if none is left the mix is refused.
+type mixSource struct{}
+
+func (mixSource) Name() string { return "mix" }
+
+// MinEntropy claims only the jitter share: the OS part is stronger, so this
+// is a lower bound for the concatenated Raw output.
+func (mixSource) MinEntropy() float64 { return jitterSource{}.MinEntropy() }
+func (mixSource) Raw(es EntropySpec, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	if b, err := rawFromOS(32); err == nil {
+		out = append(out, b...)
+	}
+	if b, err := jitterSamples(48); err == nil {
+		out = append(out, b...)
+	}
+	for _, u := range es.HTTP {
+		if b, err := rawFromHTTP([]string{u}); err == nil {
+			out = append(out, b...)
 		}
-		sum := h.Sum(nil)
-		// derive final seed as SHA256(sum || label) first 8 bytes
-		lab := []byte("seed-mix-v1")
-		s2 := sha256.Sum256(append(sum, lab...))
-		seed = int64(binary.LittleEndian.Uint64(s2[:8]))
-		tag = "mode:mix http=" + hexOfURLs(es.HTTP)
-		if len(perSeedsStr) > 0 {
-			tag += " per_seeds=" + strings.Join(perSeedsStr, ",")
+	}
+	if len(out) == 0 {
+		return nil, errNoHealthySource
+	}
+	return out[:min(n, len(out))], nil
+}
+func (mixSource) Record(es EntropySpec) (EntropyRecord, error) {
+	var rec EntropyRecord
+	raw := make([][]byte, 0, 4)
+	exclude := func(err error) {
+		log.Printf("entropy mix: excluding source: %v", err)
+		rec.HealthFailures = append(rec.HealthFailures, err.Error())
+	}
+	if b, err := rawFromOS(32); err != nil {
+		exclude(err)
+	} else {
+		raw = append(raw, b)
+	}
+	if b, err := rawFromJitter(48); err != nil {
+		exclude(err)
+	} else {
+		raw = append(raw, b)
+	}
+	perSeedsStr := make([]string, 0, len(es.HTTP))
+	perSeeds := make([]int64, 0, len(es.HTTP))
+	if len(es.HTTP) > 0 {
+		// for each URL, fetch independently and include its raw into mix
+		for _, u := range es.HTTP {
+			b, err := rawFromHTTP([]string{u})
+			if err != nil {
+				exclude(err)
+				continue
+			}
+			raw = append(raw, b)
+			var s int64
+			if len(b) >= 8 {
+				s = int64(binary.LittleEndian.Uint64(b[:8]))
+			} else {
+				// fallback: mix with OS bytes
+				extra, _ := rawFromOS(8)
+				buf := append(b, extra...)
+				s = int64(binary.LittleEndian.Uint64(buf[:8]))
+			}
+			perSeeds = append(perSeeds, s)
+			perSeedsStr = append(perSeedsStr, itoa64(s))
 		}
-		return seed, tag, perSeeds
-	default:
-		return seedFromOS(), "mode:mix", nil
 	}
+	if len(raw) == 0 {
+		return rec, fmt.Errorf("%w: %s", errNoHealthySource, strings.Join(rec.HealthFailures, "; "))
+	}
+	h := sha256.New()
+	for _, b := range raw {
+		h.Write(b)
+	}
+	sum := h.Sum(nil)
+	// derive final seed as SHA256(sum || label) first 8 bytes
+	lab := []byte("seed-mix-v1")
+	s2 := sha256.Sum256(append(sum, lab...))
+	rec.Seed = int64(binary.LittleEndian.Uint64(s2[:8]))
+	rec.Tag = "mode:mix http=" + hexOfURLs(es.HTTP)
+	rec.PerSeeds = perSeeds
+	if len(perSeedsStr) > 0 {
+		rec.Tag += " per_seeds=" + strings.Join(perSeedsStr, ",")
+	}
+	return rec, nil
 }
 
-func seedFromOS() int64 {
-	var b [8]byte
-	_, _ = io.ReadFull(rand.Reader, b[:])
-	return int64(binary.LittleEndian.Uint64(b[:]))
+func seedFromOS() (int64, error) {
+	b, err := rawFromOS(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b)), nil
 }
-func rawFromOS(n int) []byte {
+
+// rawFromOS reads n bytes from crypto/rand; every byte is health-tested.
+func rawFromOS(n int) ([]byte, error) {
 	b := make([]byte, n)
-	_, _ = io.ReadFull(rand.Reader, b)
-	return b
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, fmt.Errorf("os entropy: %w", err)
+	}
+	if err := healthOS.feedBytes(b); err != nil {
+		return nil, err
+	}
+	return b, nil
 }
 
 // джиттер: многократно измеряем наносекундные интервалы, мешаем SHA256
-func seedFromJitter(rounds int) int64 {
-	b := rawFromJitter(rounds)
+func seedFromJitter(rounds int) (int64, error) {
+	b, err := rawFromJitter(rounds)
+	if err != nil {
+		return 0, err
+	}
 	var out [8]byte
 	copy(out[:], b[:8])
-	return int64(binary.LittleEndian.Uint64(out[:]))
+	return int64(binary.LittleEndian.Uint64(out[:])), nil
 }
-func rawFromJitter(rounds int) []byte {
+
+// rawFromJitter conditions `rounds` interval measurements with SHA256; the
+// low byte of each interval is health-tested before it is hashed.
+func rawFromJitter(rounds int) ([]byte, error) {
 	h := sha256.New()
 	tmp := make([]byte, 8)
+	samples := make([]byte, rounds)
 	for i := 0; i < rounds; i++ {
 		t0 := time.Now()
 		spin := 100 + (i % 17)
@@ -98,26 +279,59 @@ func rawFromJitter(rounds int) []byte {
 		}
 		time.Sleep(0)
 		dt := time.Since(t0).Nanoseconds()
+		samples[i] = byte(dt)
 		binary.LittleEndian.PutUint64(tmp, uint64(dt))
 		h.Write(tmp)
 		binary.LittleEndian.PutUint64(tmp, uint64(time.Now().UnixNano()))
 		h.Write(tmp)
 	}
-	return h.Sum(nil)
+	if err := healthJitter.feedBytes(samples); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
 }
 
-func seedFromHTTP(urls []string) int64 {
-	b := rawFromHTTP(urls)
+// jitterSamples returns n raw (unhashed) jitter samples: the low byte of
+// each measured interval, as used for health tests and entropy estimation.
+func jitterSamples(n int) ([]byte, error) {
+	out := make([]byte, n)
+	for i := 0; i < n; i++ {
+		t0 := time.Now()
+		spin := 100 + (i % 17)
+		for k := 0; k < spin; k++ { // пустая работа
+		}
+		time.Sleep(0)
+		out[i] = byte(time.Since(t0).Nanoseconds())
+	}
+	if err := healthJitter.feedBytes(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func seedFromHTTP(urls []string) (int64, error) {
+	b, err := rawFromHTTP(urls)
+	if err != nil {
+		return 0, err
+	}
 	if len(b) < 8 {
-		b = append(b, rawFromOS(8)...)
+		extra, err := rawFromOS(8)
+		if err != nil {
+			return 0, err
+		}
+		b = append(b, extra...)
 	}
-	return int64(binary.LittleEndian.Uint64(b[:8]))
+	return int64(binary.LittleEndian.Uint64(b[:8])), nil
 }
-func rawFromHTTP(urls []string) []byte {
+
+// rawFromHTTP fetches urls in order. Each response body is one health-test
+// sample for its URL; a failing URL makes the whole read fail.
+func rawFromHTTP(urls []string) ([]byte, error) {
 	cli := &http.Client{Timeout: 3 * time.Second}
 	h := sha256.New()
 	var direct []byte
 	var gotDirect bool
+	var healthErr error
 	for _, u := range urls {
 		if strings.TrimSpace(u) == "" {
 			continue
@@ -131,6 +345,10 @@ func rawFromHTTP(urls []string) []byte {
 				// мешаем статус, заголовки и кусок тела
 				// try to read entire small body
 				body, _ := io.ReadAll(resp.Body)
+				if err := healthForURL(u).feed(responseSample(body)); err != nil {
+					healthErr = err
+					return
+				}
 				sbody := strings.TrimSpace(string(body))
 				// if body is 64-hex chars, use raw bytes directly
 				// if body is 64-hex chars, decode it; otherwise take trimmed plain text
@@ -171,14 +389,18 @@ func rawFromHTTP(urls []string) []byte {
 				}
 				h.Write(tmp)
 			}()
+			if healthErr != nil {
+				cancel()
+				return nil, healthErr
+			}
 			if gotDirect {
 				cancel()
-				return direct
+				return direct, nil
 			}
 		}
 		cancel()
 	}
-	return h.Sum(nil)
+	return h.Sum(nil), nil
 }
 
 func hexOfURLs(v []string) string {
@@ -0,0 +1,772 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+/* ===========================
+   SP 800-90B: оценки min-entropy (non-IID, §6.3)
+   =========================== */
+
+// Оценщики работают над сырыми сэмплами источника (EntropySource.Raw), а не
+// над выходом DRBG: задача — понять, сколько энтропии реально даёт источник и
+// сколько сырья нужно собрать в mix.
+
+const (
+	zAlpha      = 2.5758293035489004 // 99% two-sided normal quantile
+	tupleCutoff = 35                 // t-tuple / LRS occurrence threshold
+	// LRS stops at this width. Only a nearly stuck source repeats longer
+	// substrings, and MCV/t-tuple already rate such a source close to zero.
+	maxLRSWidth   = 128
+	maxEstSamples = 1_000_000
+	// /entropy/estimate is unauthenticated: ~1.5 s of CPU at most, one at a
+	// time. Full 90B runs (1M samples) go through --estimate.
+	maxHTTPEstSamples = 20_000
+)
+
+// estimateSources are the sources whose Raw is unconditioned output. http
+// and mix hash what they fetch (an estimate of SHA-256 output measures
+// nothing), repro and beacon are public values.
+var estimateSources = map[string]bool{"os": true, "jitter": true}
+
+// estimateBusy lets one HTTP estimate run at a time.
+var estimateBusy = make(chan struct{}, 1)
+
+// EstimatorResult is one estimator's min-entropy (bits per sample).
+type EstimatorResult struct {
+	Name       string  `json:"name"`
+	MinEntropy float64 `json:"min_entropy"`
+}
+
+// EntropyEstimate is the full 90B §3.1.3 result for a batch of byte samples.
+type EntropyEstimate struct {
+	Source        string            `json:"source"`
+	Samples       int               `json:"samples"`
+	BitsPerSample int               `json:"bits_per_sample"`
+	Original      []EstimatorResult `json:"original"`  // on 8-bit symbols
+	Bitstring     []EstimatorResult `json:"bitstring"` // on the MSB-first bit expansion
+	HOriginal     float64           `json:"h_original"`
+	HBitstring    float64           `json:"h_bitstring"`
+	MinEntropy    float64           `json:"min_entropy"` // min(H_original, 8*H_bitstring)
+	Claimed       float64           `json:"claimed"`     // EntropySource.MinEntropy()
+	// raw bytes needed for 256 bits of min-entropy at the estimated rate
+	BytesFor256 float64 `json:"bytes_for_256_bits"`
+}
+
+// estimateMinEntropy runs every non-IID estimator on byte samples and on
+// their bitstring, and combines them as min(H_original, 8*H_bitstring).
+func estimateMinEntropy(samples []byte) EntropyEstimate {
+	est := EntropyEstimate{Samples: len(samples), BitsPerSample: 8}
+	est.Original = runEstimators(samples, 256, false)
+	bits := make([]byte, 0, len(samples)*8)
+	for _, b := range samples {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, (b>>uint(i))&1)
+		}
+	}
+	est.Bitstring = runEstimators(bits, 2, true)
+	est.HOriginal = minEstimate(est.Original)
+	est.HBitstring = minEstimate(est.Bitstring)
+	est.MinEntropy = math.Min(est.HOriginal, 8*est.HBitstring)
+	if est.MinEntropy > 0 {
+		est.BytesFor256 = math.Ceil(256 / est.MinEntropy)
+	}
+	return est
+}
+
+func minEstimate(rs []EstimatorResult) float64 {
+	m := math.Inf(1)
+	for _, r := range rs {
+		if r.MinEntropy < m {
+			m = r.MinEntropy
+		}
+	}
+	return m
+}
+
+// runEstimators: collision, Markov and compression are defined for binary
+// input only, so they run on the bitstring pass. Estimators that have no
+// bound for this input (e.g. no tuple reaches the cutoff) are left out.
+func runEstimators(s []byte, k int, binary bool) []EstimatorResult {
+	out := make([]EstimatorResult, 0, 10)
+	add := func(name string, h float64, ok bool) {
+		if ok {
+			// -log2(1) даёт -0; в JSON это выглядит странно
+			out = append(out, EstimatorResult{Name: name, MinEntropy: math.Max(h, 0)})
+		}
+	}
+	add("most_common_value", estMostCommon(s), true)
+	if binary {
+		add("collision", estCollision(s), true)
+		add("markov", estMarkov(s), true)
+		add("compression", estCompression(s), true)
+	}
+	tt, ttOK, lrs, lrsOK := estTupleAndLRS(s, symWidth(k))
+	add("t_tuple", tt, ttOK)
+	add("lrs", lrs, lrsOK)
+	h, ok := estMultiMCW(s, k)
+	add("multi_mcw", h, ok)
+	h, ok = estLag(s, k)
+	add("lag", h, ok)
+	h, ok = estMultiMMC(s, k)
+	add("multi_mmc", h, ok)
+	h, ok = estLZ78Y(s, k)
+	add("lz78y", h, ok)
+	return out
+}
+
+// upperBound: p + z*sqrt(p(1-p)/(n-1)), capped at 1.
+func upperBound(p float64, n int) float64 {
+	return math.Min(1, p+zAlpha*math.Sqrt(p*(1-p)/float64(n-1)))
+}
+
+// §6.3.1
+func estMostCommon(s []byte) float64 {
+	var counts [256]int
+	for _, v := range s {
+		counts[v]++
+	}
+	mx := 0
+	for _, c := range counts {
+		mx = max(mx, c)
+	}
+	return -math.Log2(upperBound(float64(mx)/float64(len(s)), len(s)))
+}
+
+// §6.3.2 (binary)
+func estCollision(s []byte) float64 {
+	ts := make([]float64, 0, len(s)/2)
+	for i := 0; i+1 < len(s); {
+		if s[i] == s[i+1] {
+			ts = append(ts, 2)
+			i += 2
+		} else if i+2 < len(s) {
+			ts = append(ts, 3)
+			i += 3
+		} else {
+			break
+		}
+	}
+	v := len(ts)
+	if v < 2 {
+		return 1
+	}
+	mean, sd := meanStd(ts)
+	xbar := mean - zAlpha*sd/math.Sqrt(float64(v))
+	// E[t] for a binary source with p = max(P(0), P(1))
+	f := func(p float64) float64 {
+		q := 1 - p
+		z := 1 / q
+		fq := 2 * (1 + z + z*z/2) / (z * z * z)
+		return p/(q*q)*(1+0.5*(1/p-1/q))*fq - p/q*0.5*(1/p-1/q)
+	}
+	p, ok := solveDecreasing(f, xbar, 0.5, 1)
+	if !ok {
+		return 1
+	}
+	return -math.Log2(p)
+}
+
+// §6.3.3 (binary)
+func estMarkov(s []byte) float64 {
+	n := len(s)
+	c1 := 0
+	var trans [2][2]float64
+	for i, v := range s {
+		c1 += int(v)
+		if i+1 < n {
+			trans[v][s[i+1]]++
+		}
+	}
+	p1 := float64(c1) / float64(n)
+	p0 := 1 - p1
+	t := func(a, b int) float64 {
+		d := trans[a][0] + trans[a][1]
+		if d == 0 {
+			return 0
+		}
+		return trans[a][b] / d
+	}
+	p00, p01, p10, p11 := t(0, 0), t(0, 1), t(1, 0), t(1, 1)
+	cands := []float64{
+		p0 * math.Pow(p00, 127),
+		p0 * math.Pow(p01, 64) * math.Pow(p10, 63),
+		p0 * p01 * math.Pow(p11, 126),
+		p1 * p10 * math.Pow(p00, 126),
+		p1 * math.Pow(p10, 64) * math.Pow(p01, 63),
+		p1 * math.Pow(p11, 127),
+	}
+	pmax := 0.0
+	for _, c := range cands {
+		pmax = math.Max(pmax, c)
+	}
+	return math.Min(-math.Log2(pmax)/128, 1)
+}
+
+// §6.3.4 (binary): Maurer-style compression estimate over 6-bit blocks.
+func estCompression(s []byte) float64 {
+	const b, q = 6, 1000
+	nb := len(s) / b
+	k := nb - q
+	if k < 2 {
+		return 1
+	}
+	blocks := make([]int, nb)
+	for i := range blocks {
+		v := 0
+		for j := 0; j < b; j++ {
+			v = v<<1 | int(s[i*b+j])
+		}
+		blocks[i] = v
+	}
+	var dict [1 << b]int
+	for i := 1; i <= q; i++ {
+		dict[blocks[i-1]] = i
+	}
+	ds := make([]float64, 0, k)
+	for i := q + 1; i <= q+k; i++ {
+		v := blocks[i-1]
+		d := i
+		if dict[v] != 0 {
+			d = i - dict[v]
+		}
+		dict[v] = i
+		ds = append(ds, math.Log2(float64(d)))
+	}
+	mean := 0.0
+	sq := 0.0
+	for _, d := range ds {
+		mean += d
+		sq += d * d
+	}
+	mean /= float64(k)
+	sd := 0.5907 * math.Sqrt(math.Max(0, sq/float64(k-1)-mean*mean))
+	xbar := mean - zAlpha*sd/math.Sqrt(float64(k))
+
+	// G(z) = 1/K * sum_{t=Q+1}^{Q+K} [sum_{u<t} log2(u) z^2 (1-z)^(u-1) + log2(t) z (1-z)^(t-1)]
+	logs := make([]float64, q+k+1)
+	for t := 1; t <= q+k; t++ {
+		logs[t] = math.Log2(float64(t))
+	}
+	g := func(z float64) float64 {
+		total, inner, pw := 0.0, 0.0, 1.0 // pw = (1-z)^(u-1)
+		for t := 1; t <= q+k; t++ {
+			if t > q {
+				total += inner + logs[t]*z*pw
+			}
+			inner += logs[t] * z * z * pw
+			pw *= 1 - z
+		}
+		return total / float64(k)
+	}
+	const m = 1<<b - 1
+	f := func(p float64) float64 { return g(p) + m*g((1-p)/m) }
+	p, ok := solveDecreasing(f, xbar, 1.0/(1<<b), 1)
+	if !ok {
+		return 1
+	}
+	return -math.Log2(p) / b
+}
+
+// §6.3.5 + §6.3.6: t-tuple and LRS share the tuple counting pass.
+func estTupleAndLRS(s []byte, width uint) (tTuple float64, tOK bool, lrs float64, lOK bool) {
+	n := len(s)
+	pmaxT := 0.0
+	pmaxL := 0.0
+	for w := 1; w < n && w <= maxLRSWidth; w++ {
+		mx, pairs := tupleCounts(s, w, width)
+		if mx >= tupleCutoff {
+			p := float64(mx) / float64(n-w+1)
+			pmaxT = math.Max(pmaxT, math.Pow(p, 1/float64(w)))
+			continue
+		}
+		if mx < 2 {
+			break // no repeated w-tuple: past the longest repeated substring
+		}
+		tot := float64(n-w+1) * float64(n-w) / 2
+		pmaxL = math.Max(pmaxL, math.Pow(pairs/tot, 1/float64(w)))
+	}
+	return -math.Log2(upperBound(pmaxT, n)), pmaxT > 0, -math.Log2(upperBound(pmaxL, n)), pmaxL > 0
+}
+
+// tupleCounts returns the count of the most common overlapping w-tuple and
+// sum C(c,2) over all distinct w-tuples.
+func tupleCounts(s []byte, w int, width uint) (mx int, pairs float64) {
+	tally := func(c int) {
+		mx = max(mx, c)
+		pairs += float64(c) * float64(c-1) / 2
+	}
+	bits := uint(w) * width
+	if bits > 128 {
+		counts := make(map[string]int, len(s))
+		for i := 0; i+w <= len(s); i++ {
+			counts[string(s[i:i+w])]++
+		}
+		for _, c := range counts {
+			tally(c)
+		}
+		return mx, pairs
+	}
+	if bits <= 64 {
+		// rolling key, then sort and count runs: much cheaper than a map
+		// when almost every tuple is distinct (the LRS range)
+		mask := ^uint64(0) >> (64 - bits)
+		keys := make([]uint64, 0, len(s)-w+1)
+		var k uint64
+		for i := 0; i < len(s); i++ {
+			k = (k<<width | uint64(s[i])) & mask
+			if i >= w-1 {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		run := 1
+		for i := 1; i <= len(keys); i++ {
+			if i < len(keys) && keys[i] == keys[i-1] {
+				run++
+				continue
+			}
+			tally(run)
+			run = 1
+		}
+		return mx, pairs
+	}
+	// rolling 128-bit key: shift the next symbol in, mask to w symbols
+	loMask, hiMask := ^uint64(0), uint64(0)
+	if bits > 64 {
+		hiMask = ^uint64(0) >> (128 - bits)
+	}
+	counts := make(map[symKey]int, len(s))
+	var k symKey
+	k.n = uint8(w)
+	for i := 0; i < len(s); i++ {
+		k.hi = (k.hi<<width | k.lo>>(64-width)) & hiMask
+		k.lo = (k.lo<<width | uint64(s[i])) & loMask
+		if i >= w-1 {
+			counts[k]++
+		}
+	}
+	for _, c := range counts {
+		tally(c)
+	}
+	return mx, pairs
+}
+
+// predictor keeps the 90B scoreboard shared by §6.3.7–6.3.10.
+type predictor struct {
+	scores  []int
+	winner  int
+	correct int
+	run     int
+	longest int
+	n       int
+}
+
+func newPredictor(subs int) *predictor { return &predictor{scores: make([]int, subs)} }
+
+// step scores one sample: preds[j] < 0 means sub-predictor j had no guess.
+// The overall guess is the current winner's; ties go to the later predictor.
+func (p *predictor) step(preds []int, actual byte) {
+	p.n++
+	if preds[p.winner] >= 0 && preds[p.winner] == int(actual) {
+		p.correct++
+		p.run++
+		p.longest = max(p.longest, p.run)
+	} else {
+		p.run = 0
+	}
+	for j, g := range preds {
+		if g == int(actual) {
+			p.scores[j]++
+			if p.scores[j] >= p.scores[p.winner] {
+				p.winner = j
+			}
+		}
+	}
+}
+
+// minEntropy combines global and local prediction bounds (§6.3.7 step 4+).
+func (p *predictor) minEntropy(k int) (float64, bool) {
+	if p.n < 2 {
+		return 0, false
+	}
+	n := float64(p.n)
+	pg := float64(p.correct) / n
+	var pGlobal float64
+	if p.correct == 0 {
+		pGlobal = 1 - math.Pow(0.01, 1/n)
+	} else {
+		pGlobal = upperBound(pg, p.n)
+	}
+	r := float64(p.longest + 1)
+	// probability that the longest run of successes is < r
+	runProb := func(pp float64) float64 {
+		q := 1 - pp
+		x := 1.0
+		for i := 0; i < 10; i++ {
+			x = 1 + q*math.Pow(pp, r)*math.Pow(x, r+1)
+		}
+		return math.Log((1-pp*x)/((r+1-r*x)*q)) - (n+1)*math.Log(x)
+	}
+	pLocal, ok := solveDecreasing(runProb, math.Log(0.99), 1e-12, 1-1e-12)
+	if !ok {
+		pLocal = 0
+	}
+	return -math.Log2(math.Max(math.Max(pGlobal, pLocal), 1/float64(k))), true
+}
+
+// §6.3.7 MultiMCW: most common value in windows of 63/255/1023/4095.
+func estMultiMCW(s []byte, k int) (float64, bool) {
+	wins := []int{63, 255, 1023, 4095}
+	type window struct {
+		counts [256]int
+		last   [256]int
+		mode   int
+	}
+	ws := make([]window, len(wins))
+	pr := newPredictor(len(wins))
+	preds := make([]int, len(wins))
+	for i := 0; i < len(s); i++ {
+		if i >= wins[0] {
+			for j, w := range wins {
+				preds[j] = -1
+				if i >= w {
+					preds[j] = ws[j].mode
+				}
+			}
+			pr.step(preds, s[i])
+		}
+		for j, w := range wins {
+			win := &ws[j]
+			v := int(s[i])
+			win.counts[v]++
+			win.last[v] = i
+			if win.counts[v] >= win.counts[win.mode] {
+				win.mode = v
+			}
+			if i >= w {
+				old := int(s[i-w])
+				win.counts[old]--
+				if old == win.mode {
+					for c := range win.counts {
+						if win.counts[c] > win.counts[win.mode] ||
+							(win.counts[c] == win.counts[win.mode] && win.last[c] > win.last[win.mode]) {
+							win.mode = c
+						}
+					}
+				}
+			}
+		}
+	}
+	return pr.minEntropy(k)
+}
+
+// §6.3.8 lag predictor with D=128.
+func estLag(s []byte, k int) (float64, bool) {
+	const d = 128
+	pr := newPredictor(d)
+	preds := make([]int, d)
+	for i := 1; i < len(s); i++ {
+		for j := 0; j < d; j++ {
+			preds[j] = -1
+			if j+1 <= i {
+				preds[j] = int(s[i-j-1])
+			}
+		}
+		pr.step(preds, s[i])
+	}
+	return pr.minEntropy(k)
+}
+
+// symKey packs up to 128 bits of symbols exactly, so tuple and context maps
+// avoid string keys. width is the bits per symbol (1 or 8).
+type symKey struct {
+	lo, hi uint64
+	n      uint8
+}
+
+func packKey(s []byte, width uint) (symKey, bool) {
+	if uint(len(s))*width > 128 {
+		return symKey{}, false
+	}
+	k := symKey{n: uint8(len(s))}
+	for _, v := range s {
+		k.hi = k.hi<<width | k.lo>>(64-width)
+		k.lo = k.lo<<width | uint64(v)
+	}
+	return k, true
+}
+
+// ctxCounter counts next-symbol frequencies per context and keeps each
+// context's current prediction (most frequent, larger symbol on ties) up to
+// date. Contexts are at most 16 symbols: binary ones index dense tables
+// (1<<len | bits), byte ones go to sparse maps.
+type ctxCounter struct {
+	limit, size int
+	width       uint
+
+	counts map[ctxNext]int
+	best   map[symKey]ctxBest
+
+	dCounts [][2]int
+	dBest   []ctxBest
+}
+
+type ctxNext struct {
+	ctx  symKey
+	next byte
+}
+
+type ctxBest struct {
+	sym   byte
+	count int // 0 = context not seen yet
+}
+
+func newCtxCounter(limit int, width uint) *ctxCounter {
+	c := &ctxCounter{limit: limit, width: width}
+	if width == 1 {
+		c.dCounts = make([][2]int, 1<<17)
+		c.dBest = make([]ctxBest, 1<<17)
+	} else {
+		c.counts = map[ctxNext]int{}
+		c.best = map[symKey]ctxBest{}
+	}
+	return c
+}
+
+func denseIndex(ctx []byte) int {
+	idx := 1
+	for _, v := range ctx {
+		idx = idx<<1 | int(v)
+	}
+	return idx
+}
+
+// add records ctx -> next; new contexts are dropped once limit is reached.
+func (c *ctxCounter) add(ctx []byte, next byte) {
+	var b ctxBest
+	var n int
+	if c.width == 1 {
+		idx := denseIndex(ctx)
+		b = c.dBest[idx]
+		if b.count == 0 {
+			if c.size >= c.limit {
+				return
+			}
+			c.size++
+		}
+		c.dCounts[idx][next]++
+		n = c.dCounts[idx][next]
+		if n > b.count || (n == b.count && next > b.sym) {
+			c.dBest[idx] = ctxBest{sym: next, count: n}
+		}
+		return
+	}
+	key, _ := packKey(ctx, c.width)
+	b, ok := c.best[key]
+	if !ok {
+		if c.size >= c.limit {
+			return
+		}
+		c.size++
+	}
+	cn := ctxNext{key, next}
+	n = c.counts[cn] + 1
+	c.counts[cn] = n
+	if n > b.count || (n == b.count && next > b.sym) {
+		c.best[key] = ctxBest{sym: next, count: n}
+	}
+}
+
+func (c *ctxCounter) predict(ctx []byte) (ctxBest, bool) {
+	if c.width == 1 {
+		b := c.dBest[denseIndex(ctx)]
+		return b, b.count > 0
+	}
+	key, _ := packKey(ctx, c.width)
+	b, ok := c.best[key]
+	return b, ok
+}
+
+// §6.3.9 MultiMMC: Markov models of order 1..16, 100000 entries each.
+func estMultiMMC(s []byte, k int) (float64, bool) {
+	const d, maxEntries = 16, 100000
+	models := make([]*ctxCounter, d)
+	for j := range models {
+		models[j] = newCtxCounter(maxEntries, symWidth(k))
+	}
+	pr := newPredictor(d)
+	preds := make([]int, d)
+	for i := 2; i < len(s); i++ {
+		// train on the transition ending at s[i-1]
+		for j := 0; j < d; j++ {
+			order := j + 1
+			if order > i-1 {
+				break
+			}
+			models[j].add(s[i-1-order:i-1], s[i-1])
+		}
+		for j := 0; j < d; j++ {
+			preds[j] = -1
+			order := j + 1
+			if order > i {
+				continue
+			}
+			if b, ok := models[j].predict(s[i-order : i]); ok {
+				preds[j] = int(b.sym)
+			}
+		}
+		pr.step(preds, s[i])
+	}
+	return pr.minEntropy(k)
+}
+
+// §6.3.10 LZ78Y with B=16 and a 65536-entry dictionary.
+func estLZ78Y(s []byte, k int) (float64, bool) {
+	const b, maxDict = 16, 65536
+	dict := newCtxCounter(maxDict, symWidth(k))
+	pr := newPredictor(1)
+	preds := []int{-1}
+	for i := b + 1; i < len(s); i++ {
+		for j := b; j >= 1; j-- {
+			dict.add(s[i-j-1:i-1], s[i-1])
+		}
+		preds[0] = -1
+		best := 0
+		for j := b; j >= 1; j-- {
+			if e, ok := dict.predict(s[i-j : i]); ok && e.count >= best {
+				preds[0], best = int(e.sym), e.count
+			}
+		}
+		pr.step(preds, s[i])
+	}
+	return pr.minEntropy(k)
+}
+
+// symWidth is the bits per symbol for an alphabet of size k (2 or 256).
+func symWidth(k int) uint {
+	if k <= 2 {
+		return 1
+	}
+	return 8
+}
+
+func meanStd(xs []float64) (mean, sd float64) {
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	for _, x := range xs {
+		sd += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(sd / float64(len(xs)-1))
+}
+
+// solveDecreasing finds p in [lo,hi] with f(p) = target for f decreasing in
+// p. ok is false when target lies above f(lo), i.e. no solution (full entropy).
+func solveDecreasing(f func(float64) float64, target, lo, hi float64) (float64, bool) {
+	if f(lo) < target {
+		return 0, false
+	}
+	for i := 0; i < 64; i++ {
+		mid := (lo + hi) / 2
+		if f(mid) > target {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2, true
+}
+
+// collectSamples reads n raw bytes from src, calling Raw until it has enough.
+func collectSamples(src EntropySource, es EntropySpec, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		b, err := src.Raw(es, n-len(out))
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return nil, errors.New("source returned no data")
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+// runEstimate resolves the source by name, collects samples and estimates.
+// At most limit samples.
+func runEstimate(source string, es EntropySpec, n, limit int) (EntropyEstimate, error) {
+	src, ok := lookupEntropySource(source)
+	if !ok || !estimateSources[source] {
+		return EntropyEstimate{}, fmt.Errorf("source %q can't be estimated (raw sources: os,jitter)", source)
+	}
+	if n < 2 || n > limit {
+		return EntropyEstimate{}, fmt.Errorf("samples must be in [2, %d]", limit)
+	}
+	raw, err := collectSamples(src, es, n)
+	if err != nil {
+		return EntropyEstimate{}, err
+	}
+	est := estimateMinEntropy(raw)
+	est.Source = source
+	est.Claimed = src.MinEntropy()
+	// sort for stable output
+	sort.Slice(est.Original, func(i, j int) bool { return est.Original[i].Name < est.Original[j].Name })
+	sort.Slice(est.Bitstring, func(i, j int) bool { return est.Bitstring[i].Name < est.Bitstring[j].Name })
+	return est, nil
+}
+
+// GET /entropy/estimate?source=jitter|os&samples=N
+func entropyEstimateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	source := strings.ToLower(q.Get("source"))
+	if source == "" {
+		source = "jitter"
+	}
+	select {
+	case estimateBusy <- struct{}{}:
+		defer func() { <-estimateBusy }()
+	default:
+		http.Error(w, "estimate already running", http.StatusTooManyRequests)
+		return
+	}
+	est, err := runEstimate(source, EntropySpec{Mode: source}, atoi(q.Get("samples"), maxHTTPEstSamples), maxHTTPEstSamples)
+	if err != nil {
+		http.Error(w, "estimate: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(est)
+}
+
+// estimateCLI: go run . --estimate <source> [samples]
+func estimateCLI(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: --estimate <source> [samples]")
+	}
+	n := 100_000
+	if len(args) >= 2 {
+		n = atoi(args[1], n)
+	}
+	est, err := runEstimate(strings.ToLower(args[0]), EntropySpec{Mode: args[0]}, n, maxEstSamples)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(est)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Детерминированная арифметика симуляции (Provenance.FloatMode).
+//
+// Go разрешает компилятору сливать x*y + z в одну инструкцию FMA (arm64,
+// ppc64le, s390x, amd64 с GOAMD64=v3): результат округляется один раз, и
+// pathDigest транзакции, созданной на amd64, расходится с пересчётом на
+// другой архитектуре. Явное преобразование float64(x*y) по спецификации
+// округляет произведение и запрещает слияние, поэтому в коде симуляции
+// (motion.go, motionlaw.go, interact.go) каждое произведение, которое
+// складывается или вычитается, обёрнуто в float64(). Sin, Cos, Atan2 и Hypot
+// из math внутри тоже сливаются (а на s390x написаны на ассемблере), так что
+// симуляция зовёт их копии ниже — тот же алгоритм Go, те же константы, с
+// теми же обёртками.
+//
+// strict-v1 даёт ровно то, что считала прежняя сборка для amd64 без FMA, —
+// транзакции без FloatMode, созданные на amd64, проверяются им же. Режим
+// пишется в provenance, чтобы смену арифметики можно было выпустить как
+// strict-v2, не ломая проверку старых транзакций. Эталонные digest'ы
+// проверяет self-test (simVectors в selftest.go).
+
+const floatModeStrict = "strict-v1"
+
+// checkFloatMode: "" — транзакции до появления режима, считаются как strict-v1.
+func checkFloatMode(mode string) error {
+	switch mode {
+	case "", floatModeStrict:
+		return nil
+	}
+	return fmt.Errorf("%w: float_mode %q", errSimUnsupported, mode)
+}
+
+// Коэффициенты и разбиение Pi/4 — из math/sin.go.
+var detSinCoef = [...]float64{
+	1.58962301576546568060e-10,
+	-2.50507477628578072866e-8,
+	2.75573136213857245213e-6,
+	-1.98412698295895385996e-4,
+	8.33333333332211858878e-3,
+	-1.66666666666666307295e-1,
+}
+
+var detCosCoef = [...]float64{
+	-1.13585365213876817300e-11,
+	2.08757008419747316778e-9,
+	-2.75573141792967388112e-7,
+	2.48015872888517045348e-5,
+	-1.38888888888730564116e-3,
+	4.16666666666665929218e-2,
+}
+
+const (
+	detPI4A = 7.85398125648498535156e-1
+	detPI4B = 3.77489470793079817668e-8
+	detPI4C = 2.69515142907905952645e-15
+
+	// выше — редукция Пэйна–Хэнека (detTrigReduce)
+	detReduceThreshold = 1 << 29
+)
+
+// detOctant приводит |x| к октанту j и остатку z из [-Pi/4, Pi/4].
+func detOctant(x float64) (j uint64, z float64) {
+	if x >= detReduceThreshold {
+		return detTrigReduce(x)
+	}
+	j = uint64(x * (4 / math.Pi))
+	y := float64(j)
+	if j&1 == 1 {
+		j++
+		y++
+	}
+	j &= 7
+	z = float64(float64(x-float64(y*detPI4A))-float64(y*detPI4B)) - float64(y*detPI4C)
+	return j, z
+}
+
+// detPoly — схема Горнера по коэффициентам c, как в math/sin.go.
+func detPoly(c *[6]float64, zz float64) float64 {
+	p := c[0]
+	for _, k := range c[1:] {
+		p = float64(p*zz) + k
+	}
+	return p
+}
+
+func detSinPoly(z, zz float64) float64 {
+	return z + float64(z*zz*detPoly(&detSinCoef, zz))
+}
+
+func detCosPoly(zz float64) float64 {
+	return float64(1.0-float64(0.5*zz)) + float64(zz*zz*detPoly(&detCosCoef, zz))
+}
+
+// detSin — math.Sin без FMA.
+func detSin(x float64) float64 {
+	switch {
+	case x == 0 || math.IsNaN(x):
+		return x
+	case math.IsInf(x, 0):
+		return math.NaN()
+	}
+	sign := false
+	if x < 0 {
+		x = -x
+		sign = true
+	}
+	j, z := detOctant(x)
+	if j > 3 {
+		sign = !sign
+		j -= 4
+	}
+	zz := z * z
+	var y float64
+	if j == 1 || j == 2 {
+		y = detCosPoly(zz)
+	} else {
+		y = detSinPoly(z, zz)
+	}
+	if sign {
+		y = -y
+	}
+	return y
+}
+
+// detCos — math.Cos без FMA.
+func detCos(x float64) float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return math.NaN()
+	}
+	sign := false
+	j, z := detOctant(math.Abs(x))
+	if j > 3 {
+		j -= 4
+		sign = !sign
+	}
+	if j > 1 {
+		sign = !sign
+	}
+	zz := z * z
+	var y float64
+	if j == 1 || j == 2 {
+		y = detSinPoly(z, zz)
+	} else {
+		y = detCosPoly(zz)
+	}
+	if sign {
+		y = -y
+	}
+	return y
+}
+
+// detMPi4 — биты 4/Pi для detTrigReduce (math/trig_reduce.go).
+var detMPi4 = [...]uint64{
+	0x0000000000000001,
+	0x45f306dc9c882a53,
+	0xf84eafa3ea69bb81,
+	0xb6c52b3278872083,
+	0xfca2c757bd778ac3,
+	0x6e48dc74849ba5c0,
+	0x0c925dd413a32439,
+	0xfc3bd63962534e7d,
+	0xd1046bea5d768909,
+	0xd338e04d68befc82,
+	0x7323ac7306a673e9,
+	0x3908bf177bf25076,
+	0x3ff12fffbc0b301f,
+	0xde5e2316b414da3e,
+	0xda6cfd9e4f96136e,
+	0x9e8c7ecd3cbfd45a,
+	0xea4f758fd7cbe2f6,
+	0x7a0e73ef14a525d4,
+	0xd7f6bf623f1aba10,
+	0xac06608df8f6d757,
+}
+
+// detTrigReduce — редукция больших аргументов целочисленным умножением на
+// биты 4/Pi; плавающая точка в ней только в конце.
+func detTrigReduce(x float64) (j uint64, z float64) {
+	const (
+		pi4   = math.Pi / 4
+		shift = 52
+		mask  = 0x7ff
+		bias  = 1023
+	)
+	if x < pi4 {
+		return 0, x
+	}
+	ix := math.Float64bits(x)
+	exp := int(ix>>shift&mask) - bias - shift
+	ix &^= mask << shift
+	ix |= 1 << shift
+	digit, bitshift := uint(exp+61)/64, uint(exp+61)%64
+	z0 := (detMPi4[digit] << bitshift) | (detMPi4[digit+1] >> (64 - bitshift))
+	z1 := (detMPi4[digit+1] << bitshift) | (detMPi4[digit+2] >> (64 - bitshift))
+	z2 := (detMPi4[digit+2] << bitshift) | (detMPi4[digit+3] >> (64 - bitshift))
+	z2hi, _ := bits.Mul64(z2, ix)
+	z1hi, z1lo := bits.Mul64(z1, ix)
+	z0lo := z0 * ix
+	lo, c := bits.Add64(z1lo, z2hi, 0)
+	hi, _ := bits.Add64(z0lo, z1hi, c)
+	j = hi >> 61
+	hi = hi<<3 | lo>>61
+	lz := uint(bits.LeadingZeros64(hi))
+	e := uint64(bias - (lz + 1))
+	hi = (hi << (lz + 1)) | (lo >> (64 - (lz + 1)))
+	hi >>= 64 - shift
+	hi |= e << shift
+	z = math.Float64frombits(hi)
+	if j&1 == 1 {
+		j++
+		j &= 7
+		z--
+	}
+	return j, float64(z * pi4)
+}
+
+// detXatan — арктангенс на [0, 0.66] (math/atan.go).
+func detXatan(x float64) float64 {
+	const (
+		P0 = -8.750608600031904122785e-01
+		P1 = -1.615753718733365076637e+01
+		P2 = -7.500855792314704667340e+01
+		P3 = -1.228866684490136173410e+02
+		P4 = -6.485021904942025371773e+01
+		Q0 = +2.485846490142306297962e+01
+		Q1 = +1.650270098316988542046e+02
+		Q2 = +4.328810604912902668951e+02
+		Q3 = +4.853903996359136964868e+02
+		Q4 = +1.945506571482613964425e+02
+	)
+	z := float64(x * x)
+	p := float64(P0*z) + P1
+	q := z + Q0
+	for _, k := range [...][2]float64{{P2, Q1}, {P3, Q2}, {P4, Q3}} {
+		p = float64(p*z) + k[0]
+		q = float64(q*z) + k[1]
+	}
+	q = float64(q*z) + Q4
+	z = z * p / q
+	return float64(x*z) + x
+}
+
+func detSatan(x float64) float64 {
+	const (
+		Morebits = 6.123233995736765886130e-17
+		Tan3pio8 = 2.41421356237309504880
+	)
+	if x <= 0.66 {
+		return detXatan(x)
+	}
+	if x > Tan3pio8 {
+		return math.Pi/2 - detXatan(1/x) + Morebits
+	}
+	return math.Pi/4 + detXatan((x-1)/(x+1)) + 0.5*Morebits
+}
+
+func detAtan(x float64) float64 {
+	if x == 0 {
+		return x
+	}
+	if x > 0 {
+		return detSatan(x)
+	}
+	return -detSatan(-x)
+}
+
+// detAtan2 — math.Atan2 без FMA.
+func detAtan2(y, x float64) float64 {
+	switch {
+	case math.IsNaN(y) || math.IsNaN(x):
+		return math.NaN()
+	case y == 0:
+		if x >= 0 && !math.Signbit(x) {
+			return math.Copysign(0, y)
+		}
+		return math.Copysign(math.Pi, y)
+	case x == 0:
+		return math.Copysign(math.Pi/2, y)
+	case math.IsInf(x, 0):
+		if math.IsInf(x, 1) {
+			if math.IsInf(y, 0) {
+				return math.Copysign(math.Pi/4, y)
+			}
+			return math.Copysign(0, y)
+		}
+		if math.IsInf(y, 0) {
+			return math.Copysign(3*math.Pi/4, y)
+		}
+		return math.Copysign(math.Pi, y)
+	case math.IsInf(y, 0):
+		return math.Copysign(math.Pi/2, y)
+	}
+	q := detAtan(y / x)
+	if x < 0 {
+		if q <= 0 {
+			return q + math.Pi
+		}
+		return q - math.Pi
+	}
+	return q
+}
+
+// detHypot — math.Hypot без FMA; тот же max·sqrt(1+(min/max)²), что и
+// ассемблерная версия для amd64.
+func detHypot(p, q float64) float64 {
+	p, q = math.Abs(p), math.Abs(q)
+	switch {
+	case math.IsInf(p, 1) || math.IsInf(q, 1):
+		return math.Inf(1)
+	case math.IsNaN(p) || math.IsNaN(q):
+		return math.NaN()
+	}
+	if p < q {
+		p, q = q, p
+	}
+	if p == 0 {
+		return 0
+	}
+	q = q / p
+	return p * math.Sqrt(1+float64(q*q))
+}
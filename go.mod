@@ -1,5 +1,13 @@
 module rng-chaos
 
-go 1.22
+go 1.22.0
 
 require github.com/rs/cors v1.8.0
+
+require (
+	github.com/cloudflare/circl v1.6.1
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/crypto v0.31.0
+)
+
+require golang.org/x/sys v0.28.0 // indirect
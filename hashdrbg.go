@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+// Hash_DRBG по SP 800-90A §10.1.1 с SHA-256 (seedlen 440) или SHA-512
+// (seedlen 888).
+type HashDRBG struct {
+	newHash func() hash.Hash
+	seedLen int // bytes
+	V       []byte
+	C       []byte
+
+	reseedCounter uint64
+}
+
+func newHashDRBG(newHash func() hash.Hash, entropy, nonce, personalization []byte) *HashDRBG {
+	d := &HashDRBG{newHash: newHash, seedLen: 55, reseedCounter: 1}
+	if newHash().Size() > 32 {
+		d.seedLen = 111
+	}
+	d.V = d.df(d.seedLen, concatBytes(entropy, nonce, personalization))
+	d.C = d.df(d.seedLen, []byte{0x00}, d.V)
+	return d
+}
+
+// df is Hash_df (§10.3.1): n bytes of Hash(counter || n*8 || input...).
+func (d *HashDRBG) df(n int, input ...[]byte) []byte {
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(n*8))
+	out := make([]byte, 0, n+64)
+	h := d.newHash()
+	for counter := byte(1); len(out) < n; counter++ {
+		hdr[0] = counter
+		h.Reset()
+		h.Write(hdr[:])
+		for _, in := range input {
+			h.Write(in)
+		}
+		out = h.Sum(out)
+	}
+	return out[:n]
+}
+
+func (d *HashDRBG) hash(parts ...[]byte) []byte {
+	h := d.newHash()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// addMod: dst = (dst + src) mod 2^(8*len(dst)), big-endian, src right-aligned.
+func addMod(dst, src []byte) {
+	carry := 0
+	for i, j := len(dst)-1, len(src)-1; i >= 0; i, j = i-1, j-1 {
+		sum := int(dst[i]) + carry
+		if j >= 0 {
+			sum += int(src[j])
+		}
+		dst[i] = byte(sum)
+		carry = sum >> 8
+	}
+}
+
+func (d *HashDRBG) Reseed(entropy, additional []byte) error {
+	if err := checkDRBGInput(entropy, additional); err != nil {
+		return err
+	}
+	d.V = d.df(d.seedLen, []byte{0x01}, d.V, entropy, additional)
+	d.C = d.df(d.seedLen, []byte{0x00}, d.V)
+	d.reseedCounter = 1
+	return nil
+}
+
+func (d *HashDRBG) Generate(n int, additional []byte) ([]byte, error) {
+	if err := checkGenerate(n, additional, d.reseedCounter); err != nil {
+		return nil, err
+	}
+	if len(additional) > 0 {
+		addMod(d.V, d.hash([]byte{0x02}, d.V, additional))
+	}
+
+	// Hashgen
+	data := append([]byte(nil), d.V...)
+	out := make([]byte, 0, n+64)
+	h := d.newHash()
+	for len(out) < n {
+		h.Reset()
+		h.Write(data)
+		out = h.Sum(out)
+		addMod(data, []byte{1})
+	}
+
+	var rc [8]byte
+	binary.BigEndian.PutUint64(rc[:], d.reseedCounter)
+	hv := d.hash([]byte{0x03}, d.V)
+	addMod(d.V, hv)
+	addMod(d.V, d.C)
+	addMod(d.V, rc[:])
+	d.reseedCounter++
+	return out[:n], nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Непрерывные health-тесты SP 800-90B §4.4: Repetition Count Test (RCT) и
+// Adaptive Proportion Test (APT). Каждый сырой сэмпл проходит через тестер
+// своего источника до того, как попадёт в SHA256-кондиционирование.
+
+// healthAlpha is the false-positive probability per test (2^-20, as in 90B).
+const healthAlpha = 1.0 / (1 << 20)
+
+// HealthFailure is returned by raw readers when a source trips a health test.
+type HealthFailure struct {
+	Source string
+	Test   string // "repetition_count" | "adaptive_proportion"
+	Count  int
+	Cutoff int
+}
+
+func (e *HealthFailure) Error() string {
+	return fmt.Sprintf("%s: %s test failed (%d >= cutoff %d)", e.Source, e.Test, e.Count, e.Cutoff)
+}
+
+type healthTest struct {
+	mu        sync.Mutex
+	source    string
+	rctCutoff int
+	aptWindow int
+	aptCutoff int
+
+	// repetition count state
+	last    uint64
+	hasLast bool
+	run     int
+
+	// adaptive proportion state
+	aptRef   uint64
+	aptSeen  int
+	aptMatch int
+}
+
+// newHealthTest builds a tester for a source claiming h bits of min-entropy
+// per sample. window is the APT window: 1024 for binary, 512 otherwise.
+func newHealthTest(source string, h float64, window int) *healthTest {
+	return &healthTest{
+		source:    source,
+		rctCutoff: rctCutoff(h),
+		aptWindow: window,
+		aptCutoff: aptCutoff(window, h),
+	}
+}
+
+// rctCutoff: C = 1 + ceil(-log2(alpha) / H).
+func rctCutoff(h float64) int {
+	return 1 + int(math.Ceil(-math.Log2(healthAlpha)/h))
+}
+
+// aptCutoff: C = 1 + CRITBINOM(W, 2^-H, 1-alpha), i.e. one more than the
+// smallest k whose binomial CDF reaches 1-alpha.
+func aptCutoff(window int, h float64) int {
+	p := math.Exp2(-h)
+	lw, _ := math.Lgamma(float64(window + 1))
+	cdf := 0.0
+	for k := 0; k <= window; k++ {
+		lk, _ := math.Lgamma(float64(k + 1))
+		lr, _ := math.Lgamma(float64(window - k + 1))
+		cdf += math.Exp(lw - lk - lr + float64(k)*math.Log(p) + float64(window-k)*math.Log1p(-p))
+		if cdf >= 1-healthAlpha {
+			return k + 1
+		}
+	}
+	return window
+}
+
+// feed runs both tests over samples. The state is continuous across calls,
+// so a source stuck on one value keeps failing until its output changes.
+// The first failure seen in this batch is returned.
+func (t *healthTest) feed(samples ...uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var fail error
+	for _, s := range samples {
+		if t.hasLast && s == t.last {
+			t.run++
+		} else {
+			t.last, t.hasLast, t.run = s, true, 1
+		}
+		if t.run >= t.rctCutoff && fail == nil {
+			fail = &HealthFailure{Source: t.source, Test: "repetition_count", Count: t.run, Cutoff: t.rctCutoff}
+		}
+
+		if t.aptSeen == 0 {
+			t.aptRef, t.aptMatch = s, 0
+		}
+		if s == t.aptRef {
+			t.aptMatch++
+		}
+		t.aptSeen++
+		if t.aptMatch >= t.aptCutoff && fail == nil {
+			fail = &HealthFailure{Source: t.source, Test: "adaptive_proportion", Count: t.aptMatch, Cutoff: t.aptCutoff}
+		}
+		if t.aptSeen == t.aptWindow {
+			t.aptSeen = 0
+		}
+	}
+	return fail
+}
+
+// feedBytes treats every byte as one sample.
+func (t *healthTest) feedBytes(b []byte) error {
+	samples := make([]uint64, len(b))
+	for i, v := range b {
+		samples[i] = uint64(v)
+	}
+	return t.feed(samples...)
+}
+
+// httpSampleEntropy is the per-response claim used for HTTP health tests:
+// one sample is one response body, so a cutoff of a handful of identical
+// responses in a row flags an endpoint that stopped changing.
+const httpSampleEntropy = 4
+
+var (
+	healthOS     = newHealthTest("os", osSource{}.MinEntropy(), 512)
+	healthJitter = newHealthTest("jitter", jitterSource{}.MinEntropy(), 512)
+
+	healthHTTPMu sync.Mutex
+	healthHTTP   = map[string]*healthTest{}
+)
+
+// healthForURL returns the (lazily created) tester for one HTTP endpoint.
+func healthForURL(u string) *healthTest {
+	healthHTTPMu.Lock()
+	defer healthHTTPMu.Unlock()
+	t, ok := healthHTTP[u]
+	if !ok {
+		t = newHealthTest("http:"+u, httpSampleEntropy, 512)
+		healthHTTP[u] = t
+	}
+	return t
+}
+
+// responseSample condenses a response body into one 64-bit sample.
+func responseSample(body []byte) uint64 {
+	h := sha256.Sum256(body)
+	return binary.LittleEndian.Uint64(h[:8])
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"math"
+)
+
+// Взаимодействие точек (MotionSpec.Collide/Attract/Flock): упругие
+// столкновения, притяжение/отталкивание и boids. Соседи ищутся по
+// равномерной сетке с ячейкой не меньше радиуса действия, так что на точку
+// приходится O(соседей), а не O(n).
+//
+// Все силы тика считаются по снимку позиций и скоростей на его начало
+// (prepare, последовательно), а соседи обходятся в фиксированном порядке
+// (ячейки по порядку, внутри — по индексу точки). Поэтому результат, а с ним
+// и pathDigest, не зависит от числа воркеров, и /tx/{id}/verify повторяет
+// прогон по полям MotionSpec из provenance.
+
+const (
+	defaultCollideRadius = 6.0
+	defaultAttractRange  = 150.0
+	defaultFlockRange    = 60.0
+
+	// веса boids: разделение, выравнивание скоростей, сплочённость
+	flockSeparation = 1.0
+	flockAlignment  = 0.125
+	flockCohesion   = 0.01
+)
+
+type interactions struct {
+	collide      bool
+	radius       float64
+	attract      float64
+	attractRange float64
+	flock        float64
+	flockRange   float64
+
+	// снимок тика
+	pos, vel []XY
+	// сетка: точки ячейки c — items[start[c]:start[c+1]]
+	cell       float64
+	cols, rows int
+	start      []int
+	fill       []int
+	items      []int
+	cellOf     []int
+}
+
+// newInteractions возвращает nil, если взаимодействие выключено: прогон
+// тогда точь-в-точь прежний.
+func newInteractions(spec MotionSpec, w, h, n int) *interactions {
+	if !spec.Collide && spec.Attract == 0 && spec.Flock == 0 {
+		return nil
+	}
+	ia := &interactions{
+		collide:      spec.Collide,
+		radius:       spec.CollideRadius,
+		attract:      spec.Attract,
+		attractRange: spec.AttractRange,
+		flock:        spec.Flock,
+		flockRange:   spec.FlockRange,
+		pos:          make([]XY, n),
+		vel:          make([]XY, n),
+		items:        make([]int, n),
+		cellOf:       make([]int, n),
+	}
+	reach := 1.0
+	if ia.collide {
+		reach = max(reach, 2*ia.radius)
+	}
+	if ia.attract != 0 {
+		reach = max(reach, ia.attractRange)
+	}
+	if ia.flock != 0 {
+		reach = max(reach, ia.flockRange)
+	}
+	// ячеек не больше, чем нужно для n точек: крупнее ячейка — только лишние
+	// проверки расстояний, на результат не влияет
+	for {
+		ia.cols = int(float64(w)/reach) + 1
+		ia.rows = int(float64(h)/reach) + 1
+		if ia.cols*ia.rows <= 4*n+16 {
+			break
+		}
+		reach *= 2
+	}
+	ia.cell = reach
+	ia.start = make([]int, ia.cols*ia.rows+1)
+	ia.fill = make([]int, len(ia.start))
+	return ia
+}
+
+// resolveInteractions подставляет радиусы по умолчанию для включённых
+// взаимодействий, чтобы они попали в provenance.
+func resolveInteractions(spec *MotionSpec) {
+	if spec.Collide && spec.CollideRadius <= 0 {
+		spec.CollideRadius = defaultCollideRadius
+	}
+	if spec.Attract != 0 && spec.AttractRange <= 0 {
+		spec.AttractRange = defaultAttractRange
+	}
+	if spec.Flock != 0 && spec.FlockRange <= 0 {
+		spec.FlockRange = defaultFlockRange
+	}
+}
+
+func (ia *interactions) cellIndex(x, y float64) int {
+	// NaN и выход за холст — в крайние ячейки; зажимаем до перевода в int,
+	// он для NaN и бесконечностей зависит от платформы
+	fx, fy := x/ia.cell, y/ia.cell
+	if !(fx >= 0) {
+		fx = 0
+	}
+	if !(fy >= 0) {
+		fy = 0
+	}
+	cx := int(min(fx, float64(ia.cols-1)))
+	cy := int(min(fy, float64(ia.rows-1)))
+	return cy*ia.cols + cx
+}
+
+// prepare снимает позиции и скорости и раскладывает точки по ячейкам
+// (сортировка подсчётом — внутри ячейки индексы по возрастанию).
+func (ia *interactions) prepare(pts []*mover) {
+	clear(ia.start)
+	for i, p := range pts {
+		ia.pos[i] = XY{p.x, p.y}
+		ia.vel[i] = XY{p.vx, p.vy}
+		c := ia.cellIndex(p.x, p.y)
+		ia.cellOf[i] = c
+		ia.start[c+1]++
+	}
+	for c := 1; c < len(ia.start); c++ {
+		ia.start[c] += ia.start[c-1]
+	}
+	copy(ia.fill, ia.start)
+	for i, c := range ia.cellOf {
+		ia.items[ia.fill[c]] = i
+		ia.fill[c]++
+	}
+}
+
+// neighbours вызывает fn для точек из ячейки точки i и восьми соседних.
+func (ia *interactions) neighbours(i int, fn func(j int)) {
+	c := ia.cellOf[i]
+	cx, cy := c%ia.cols, c/ia.cols
+	for y := max(cy-1, 0); y <= min(cy+1, ia.rows-1); y++ {
+		for x := max(cx-1, 0); x <= min(cx+1, ia.cols-1); x++ {
+			k := y*ia.cols + x
+			for _, j := range ia.items[ia.start[k]:ia.start[k+1]] {
+				if j != i {
+					fn(j)
+				}
+			}
+		}
+	}
+}
+
+// collideWith — упругое столкновение равных масс: точка i меняет нормальную
+// к контакту составляющую скорости на ту, что была у соседа, и выталкивается
+// на половину перекрытия. Сосед сделает то же со своей стороны по тому же
+// снимку.
+func (ia *interactions) collideWith(i int, p *mover) {
+	me, mv := ia.pos[i], ia.vel[i]
+	d2max := 4 * ia.radius * ia.radius
+	ia.neighbours(i, func(j int) {
+		o, ov := ia.pos[j], ia.vel[j]
+		dx, dy := me.X-o.X, me.Y-o.Y
+		d2 := float64(dx*dx) + float64(dy*dy)
+		if d2 >= d2max {
+			return
+		}
+		var nx, ny, d float64
+		if d2 == 0 {
+			// совпавшие точки: расталкиваем по оси x, меньший индекс — влево
+			nx, ny = 1, 0
+			if i < j {
+				nx = -1
+			}
+		} else {
+			d = math.Sqrt(d2)
+			nx, ny = dx/d, dy/d
+		}
+		// сближаются — обмен нормальными компонентами
+		if rel := float64((mv.X-ov.X)*nx) + float64((mv.Y-ov.Y)*ny); rel < 0 {
+			p.vx -= float64(rel * nx)
+			p.vy -= float64(rel * ny)
+		}
+		push := (float64(2*ia.radius) - d) / 2
+		p.x += float64(push * nx)
+		p.y += float64(push * ny)
+	})
+}
+
+// accel — ускорение точки i от соседей: притяжение/отталкивание с линейным
+// спадом до нуля на attractRange и силы boids в пределах flockRange.
+func (ia *interactions) accel(i int) (ax, ay float64) {
+	me, mv := ia.pos[i], ia.vel[i]
+	var sepX, sepY, velX, velY, cenX, cenY float64
+	flockN := 0
+	ia.neighbours(i, func(j int) {
+		o := ia.pos[j]
+		dx, dy := o.X-me.X, o.Y-me.Y
+		d2 := float64(dx*dx) + float64(dy*dy)
+		if d2 == 0 {
+			return
+		}
+		d := math.Sqrt(d2)
+		if ia.attract != 0 && d < ia.attractRange {
+			k := ia.attract * (1 - d/ia.attractRange) / d
+			ax += float64(k * dx)
+			ay += float64(k * dy)
+		}
+		if ia.flock != 0 && d < ia.flockRange {
+			sepX -= dx / d2
+			sepY -= dy / d2
+			velX += ia.vel[j].X
+			velY += ia.vel[j].Y
+			cenX += o.X
+			cenY += o.Y
+			flockN++
+		}
+	})
+	if flockN > 0 {
+		n := float64(flockN)
+		bx := float64(flockSeparation*sepX*ia.flockRange) + float64(flockAlignment*(velX/n-mv.X)) + float64(flockCohesion*(cenX/n-me.X))
+		by := float64(flockSeparation*sepY*ia.flockRange) + float64(flockAlignment*(velY/n-mv.Y)) + float64(flockCohesion*(cenY/n-me.Y))
+		ax += float64(ia.flock * bx)
+		ay += float64(ia.flock * by)
+	}
+	return ax, ay
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jsonStore is the default backend: everything in memory, changes in the
+// WAL (wal.go), periodic snapshots in store.json. Transactions are kept as
+// the pointers handlers put; their fields are guarded by txMutex.
+type jsonStore struct {
+	path string
+
+	mu    sync.RWMutex
+	txs   map[string]*Transaction
+	chain []Block
+	keys  []persistedKey
+	// single-key fields of stores written before the keyring
+	signingKey, ed25519Key string
+
+	wal          *walLog
+	snapshotMu   sync.Mutex
+	snapshotBusy atomic.Bool
+}
+
+// openJSONStore reads the snapshot, replays the WAL and opens it for
+// appending. A missing store.json is an empty store; a corrupt one is moved
+// aside together with the WAL built on it, and the store starts empty.
+func openJSONStore(path string) (*jsonStore, error) {
+	var p persistedStore
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		log.Printf("store: no %s, starting empty", path)
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(b, &p); err != nil {
+			// backup corrupt store (and the log on top of it) and start empty
+			ts := time.Now().Format("20060102-150405")
+			bad := path + ".corrupt-" + ts
+			if err2 := os.Rename(path, bad); err2 != nil {
+				log.Printf("store: failed to move invalid store.json: %v (parse error: %v)", err2, err)
+				// return the original parse error if we couldn't move the file
+				return nil, err
+			}
+			log.Printf("store: moved invalid store.json to %s due to parse error: %v", bad, err)
+			if err2 := os.Rename(walDir(), walDir()+".corrupt-"+ts); err2 != nil && !errors.Is(err2, os.ErrNotExist) {
+				return nil, fmt.Errorf("store.json invalid, moved to %s, but the wal could not be moved: %w", bad, err2)
+			}
+			p = persistedStore{}
+		}
+	}
+	s := &jsonStore{
+		path:       path,
+		txs:        p.TxStore,
+		chain:      p.Chain,
+		keys:       p.Keyring,
+		signingKey: p.SigningKey,
+		ed25519Key: p.Ed25519Key,
+	}
+	if s.txs == nil {
+		s.txs = map[string]*Transaction{}
+	}
+	if s.chain == nil {
+		s.chain = []Block{}
+	}
+	seg, replayed, err := s.replay(walDir(), p.WALSegment)
+	if err != nil {
+		return nil, err
+	}
+	if s.wal, err = openWAL(walDir(), seg); err != nil {
+		return nil, err
+	}
+	s.wal.records = replayed
+	log.Printf("loaded store: %d transactions, %d blocks (%d wal records replayed)", len(s.txs), len(s.chain), replayed)
+
+	if p.WALSegment == 0 {
+		if len(b) > 0 {
+			log.Printf("store: importing %s written before the wal", path)
+		}
+		// first snapshot with a wal marker
+		if err := s.snapshot(); err != nil {
+			log.Printf("failed to write snapshot: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// replay applies segments >= from on top of the loaded snapshot. A torn
+// tail of the last segment is truncated; damage anywhere else is an error.
+// Returns the segment to continue appending to and the number of records.
+func (s *jsonStore) replay(dir string, from uint64) (seg uint64, n int, err error) {
+	segs, err := listWALSegments(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	seg = max(from, 1)
+	for i, sn := range segs {
+		if sn < from {
+			continue
+		}
+		path := walSegmentPath(dir, sn)
+		recs, good, err := readWALSegment(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		if good < st.Size() {
+			if i != len(segs)-1 {
+				return 0, 0, fmt.Errorf("wal: %s: corrupt record at offset %d", path, good)
+			}
+			log.Printf("wal: %s: truncating torn tail at offset %d (%d bytes)", path, good, st.Size()-good)
+			if err := os.Truncate(path, good); err != nil {
+				return 0, 0, err
+			}
+		}
+		for _, r := range recs {
+			if err := s.apply(r); err != nil {
+				return 0, 0, fmt.Errorf("wal: %s: %w", path, err)
+			}
+		}
+		seg, n = sn, n+len(recs)
+	}
+	return seg, n, nil
+}
+
+func (s *jsonStore) apply(r walRecord) error {
+	switch r.typ {
+	case walRecTx:
+		var tx Transaction
+		if err := json.Unmarshal(r.payload, &tx); err != nil {
+			return fmt.Errorf("tx: %w", err)
+		}
+		s.txs[tx.TxID] = &tx
+	case walRecBlock:
+		var b Block
+		if err := json.Unmarshal(r.payload, &b); err != nil {
+			return fmt.Errorf("block: %w", err)
+		}
+		switch {
+		case b.Index == len(s.chain):
+			s.chain = append(s.chain, b)
+		case b.Index < len(s.chain) && s.chain[b.Index].Hash == b.Hash:
+			// already in the snapshot
+		default:
+			return fmt.Errorf("block %d does not extend chain of %d", b.Index, len(s.chain))
+		}
+	case walRecKeyring:
+		if err := json.Unmarshal(r.payload, &s.keys); err != nil {
+			return fmt.Errorf("keyring: %w", err)
+		}
+		s.signingKey, s.ed25519Key = "", ""
+	default:
+		return fmt.Errorf("unknown record type %d", r.typ)
+	}
+	return nil
+}
+
+// snapshot writes a compacted store.json: it switches the WAL to a fresh
+// segment, writes the state and drops the older segments.
+func (s *jsonStore) snapshot() error {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	seg, err := s.wal.rotate()
+	if err != nil {
+		return err
+	}
+
+	// copy under locks; records logged after rotate may be in the copy too,
+	// replaying them over the snapshot is a no-op
+	s.mu.RLock()
+	p := persistedStore{
+		TxStore:    make(map[string]*Transaction, len(s.txs)),
+		Chain:      append([]Block(nil), s.chain...),
+		Keyring:    append([]persistedKey(nil), s.keys...),
+		SigningKey: s.signingKey,
+		Ed25519Key: s.ed25519Key,
+		WALSegment: seg,
+	}
+	txs := make([]*Transaction, 0, len(s.txs))
+	for _, tx := range s.txs {
+		txs = append(txs, tx)
+	}
+	s.mu.RUnlock()
+	for _, tx := range txs {
+		// sanitize the Simulation to avoid marshalling potentially very
+		// large simulation paths into store.json
+		c := storedTx(tx)
+		p.TxStore[c.TxID] = &c
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(s.path, data, 0o644); err != nil {
+		return err
+	}
+	s.wal.trim(seg)
+	log.Printf("store snapshot persisted to %s (wal segment %d)", s.path, seg)
+	return nil
+}
+
+// maybeSnapshot writes a snapshot in the background once enough records
+// have piled up since the last one.
+func (s *jsonStore) maybeSnapshot() {
+	if !s.wal.due() || !s.snapshotBusy.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.snapshotBusy.Store(false)
+		if err := s.snapshot(); err != nil {
+			log.Printf("wal: snapshot failed: %v", err)
+		}
+	}()
+}
+
+func (s *jsonStore) PutTx(tx *Transaction) error {
+	c := storedTx(tx)
+	rec, err := encodeWALRecord(walRecTx, &c)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if err = s.wal.append(rec); err == nil {
+		s.txs[tx.TxID] = tx
+	}
+	s.mu.Unlock()
+	s.maybeSnapshot()
+	return err
+}
+
+func (s *jsonStore) GetTx(id string) (*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tx, ok := s.txs[id]
+	if !ok {
+		return nil, errTxNotFound
+	}
+	return tx, nil
+}
+
+func (s *jsonStore) ListTx(cursor string, limit int) ([]*Transaction, string, error) {
+	s.mu.RLock()
+	ids := make([]string, 0, len(s.txs))
+	for id := range s.txs {
+		if id > cursor {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	next := ""
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+	out := make([]*Transaction, len(ids))
+	for i, id := range ids {
+		out[i] = s.txs[id]
+	}
+	s.mu.RUnlock()
+	return out, next, nil
+}
+
+func (s *jsonStore) AppendBlock(tx *Transaction, b Block) error {
+	c := storedTx(tx)
+	txRec, err := encodeWALRecord(walRecTx, &c)
+	if err != nil {
+		return err
+	}
+	blkRec, err := encodeWALRecord(walRecBlock, b)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if b.Index != len(s.chain) {
+		s.mu.Unlock()
+		return errBlockConflict
+	}
+	// log under mu so blocks reach the WAL in index order, and apply only
+	// what is durable
+	if err = s.wal.append(txRec, blkRec); err == nil {
+		s.txs[tx.TxID] = tx
+		s.chain = append(s.chain, b)
+	}
+	s.mu.Unlock()
+	s.maybeSnapshot()
+	return err
+}
+
+func (s *jsonStore) Blocks(from, to int) ([]Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	from, to = max(from, 0), min(to, len(s.chain))
+	if from >= to {
+		return nil, nil
+	}
+	return append([]Block(nil), s.chain[from:to]...), nil
+}
+
+func (s *jsonStore) Head() (int, Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.chain) == 0 {
+		return 0, Block{}, nil
+	}
+	return len(s.chain), s.chain[len(s.chain)-1], nil
+}
+
+func (s *jsonStore) Keyring() ([]persistedKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]persistedKey(nil), s.keys...), nil
+}
+
+// PutKeyring logs the keyring and writes a snapshot right away, so migrated
+// fields and weak envelopes don't linger in store.json.
+func (s *jsonStore) PutKeyring(keys []persistedKey) error {
+	rec, err := encodeWALRecord(walRecKeyring, keys)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if err = s.wal.append(rec); err == nil {
+		s.keys = append([]persistedKey(nil), keys...)
+		s.signingKey, s.ed25519Key = "", ""
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.snapshot()
+}
+
+func (s *jsonStore) legacyKeys() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.signingKey, s.ed25519Key
+}
+
+func (s *jsonStore) Close() error {
+	return s.wal.close()
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// logStore (STORE_BACKEND=log) needs nothing but the standard library:
+// store.log/tx.log and store.log/blocks.log are append-only files of WAL
+// records (wal.go), store.log/keyring.json holds the keyring. Only the
+// index — tx id → offset of its latest record, block index → offset —
+// lives in memory; it is rebuilt by scanning the files on open, and a torn
+// tail is truncated like in the WAL.
+type logStore struct {
+	dir string
+
+	mu     sync.RWMutex
+	txF    *os.File
+	blkF   *os.File
+	txOff  map[string]int64
+	ids    []string // sorted, for ListTx
+	blkOff []int64
+	last   Block
+	err    error // sticky errLogBroken
+}
+
+func openLogStore(dir string) (*logStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &logStore{dir: dir, txOff: map[string]int64{}}
+	var err error
+	s.txF, err = openLogFile(filepath.Join(dir, "tx.log"), func(off int64, r walRecord) error {
+		var tx struct {
+			TxID string `json:"tx_id"`
+		}
+		if r.typ != walRecTx {
+			return fmt.Errorf("unexpected record type %d", r.typ)
+		}
+		if err := json.Unmarshal(r.payload, &tx); err != nil {
+			return err
+		}
+		if _, ok := s.txOff[tx.TxID]; !ok {
+			s.ids = append(s.ids, tx.TxID)
+		}
+		s.txOff[tx.TxID] = off
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(s.ids)
+	s.blkF, err = openLogFile(filepath.Join(dir, "blocks.log"), func(off int64, r walRecord) error {
+		var b Block
+		if r.typ != walRecBlock {
+			return fmt.Errorf("unexpected record type %d", r.typ)
+		}
+		if err := json.Unmarshal(r.payload, &b); err != nil {
+			return err
+		}
+		if b.Index != len(s.blkOff) {
+			return fmt.Errorf("block %d out of order, want %d", b.Index, len(s.blkOff))
+		}
+		s.blkOff = append(s.blkOff, off)
+		s.last = b
+		return nil
+	})
+	if err != nil {
+		s.txF.Close()
+		return nil, err
+	}
+	log.Printf("loaded log store %s: %d transactions, %d blocks", dir, len(s.txOff), len(s.blkOff))
+	return s, nil
+}
+
+// openLogFile scans path, truncates a torn tail and opens it for appending.
+func openLogFile(path string, fn func(off int64, r walRecord) error) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	good, err := scanWALRecords(f, fn)
+	if err == nil {
+		var st os.FileInfo
+		if st, err = f.Stat(); err == nil && good < st.Size() {
+			log.Printf("store: %s: truncating torn tail at offset %d (%d bytes)", path, good, st.Size()-good)
+			err = f.Truncate(good)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+// readLogRecord reads and checks the record at off.
+func readLogRecord(f *os.File, off int64) (walRecord, error) {
+	var hdr [walHeaderSize]byte
+	if _, err := f.ReadAt(hdr[:], off); err != nil {
+		return walRecord{}, err
+	}
+	n := int(binary.LittleEndian.Uint32(hdr[0:]))
+	if n < 1 || n > walMaxRecord {
+		return walRecord{}, fmt.Errorf("%s: bad record at offset %d", f.Name(), off)
+	}
+	body := make([]byte, n)
+	if _, err := f.ReadAt(body, off+walHeaderSize); err != nil && !errors.Is(err, io.EOF) {
+		return walRecord{}, err
+	}
+	if crc32.Checksum(body, walCRC) != binary.LittleEndian.Uint32(hdr[4:]) {
+		return walRecord{}, fmt.Errorf("%s: checksum mismatch at offset %d", f.Name(), off)
+	}
+	return walRecord{typ: body[0], payload: body[1:]}, nil
+}
+
+// appendLog writes one record and fsyncs, returning its offset. A failed
+// write is cut back off the file so later records don't land behind a torn
+// one; an error wrapping errLogBroken means even that failed (or fsync did)
+// and the file must not be appended to again.
+func appendLog(f *os.File, rec []byte) (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if n, err := f.Write(rec); err != nil {
+		if n > 0 {
+			if terr := f.Truncate(st.Size()); terr != nil {
+				return 0, fmt.Errorf("%w: %s: %v (truncate: %v)", errLogBroken, f.Name(), err, terr)
+			}
+		}
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", errLogBroken, f.Name(), err)
+	}
+	return st.Size(), nil
+}
+
+var errLogBroken = errors.New("store: log file broken, restart to recover")
+
+// append is appendLog that makes errLogBroken sticky. Caller holds s.mu.
+func (s *logStore) append(f *os.File, rec []byte) (int64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	off, err := appendLog(f, rec)
+	if errors.Is(err, errLogBroken) {
+		s.err = err
+		log.Print(err)
+	}
+	return off, err
+}
+
+// putTx appends the tx record and indexes it. Caller holds s.mu.
+func (s *logStore) putTx(tx *Transaction) error {
+	c := storedTx(tx)
+	rec, err := encodeWALRecord(walRecTx, &c)
+	if err != nil {
+		return err
+	}
+	off, err := s.append(s.txF, rec)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.txOff[c.TxID]; !ok {
+		i := sort.SearchStrings(s.ids, c.TxID)
+		s.ids = append(s.ids, "")
+		copy(s.ids[i+1:], s.ids[i:])
+		s.ids[i] = c.TxID
+	}
+	s.txOff[c.TxID] = off
+	return nil
+}
+
+func (s *logStore) PutTx(tx *Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putTx(tx)
+}
+
+// getTx reads tx id. Caller holds s.mu.
+func (s *logStore) getTx(id string) (*Transaction, error) {
+	off, ok := s.txOff[id]
+	if !ok {
+		return nil, errTxNotFound
+	}
+	r, err := readLogRecord(s.txF, off)
+	if err != nil {
+		return nil, err
+	}
+	var tx Transaction
+	if err := json.Unmarshal(r.payload, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func (s *logStore) GetTx(id string) (*Transaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getTx(id)
+}
+
+func (s *logStore) ListTx(cursor string, limit int) ([]*Transaction, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.SearchStrings(s.ids, cursor)
+	if i < len(s.ids) && s.ids[i] == cursor {
+		i++
+	}
+	ids := s.ids[i:]
+	next := ""
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+	out := make([]*Transaction, 0, len(ids))
+	for _, id := range ids {
+		tx, err := s.getTx(id)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, tx)
+	}
+	return out, next, nil
+}
+
+// AppendBlock syncs the tx record before writing the block, so a block is
+// never on disk without its transaction.
+func (s *logStore) AppendBlock(tx *Transaction, b Block) error {
+	rec, err := encodeWALRecord(walRecBlock, b)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b.Index != len(s.blkOff) {
+		return errBlockConflict
+	}
+	if err := s.putTx(tx); err != nil {
+		return err
+	}
+	off, err := s.append(s.blkF, rec)
+	if err != nil {
+		return err
+	}
+	s.blkOff = append(s.blkOff, off)
+	s.last = b
+	return nil
+}
+
+func (s *logStore) Blocks(from, to int) ([]Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	from, to = max(from, 0), min(to, len(s.blkOff))
+	var out []Block
+	for i := from; i < to; i++ {
+		r, err := readLogRecord(s.blkF, s.blkOff[i])
+		if err != nil {
+			return nil, err
+		}
+		var b Block
+		if err := json.Unmarshal(r.payload, &b); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+func (s *logStore) Head() (int, Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.blkOff), s.last, nil
+}
+
+func (s *logStore) keyringPath() string {
+	return filepath.Join(s.dir, "keyring.json")
+}
+
+func (s *logStore) Keyring() ([]persistedKey, error) {
+	var keys []persistedKey
+	b, err := os.ReadFile(s.keyringPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &keys)
+	return keys, err
+}
+
+func (s *logStore) PutKeyring(keys []persistedKey) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeFileSync(s.keyringPath(), data, 0o644)
+}
+
+func (s *logStore) Close() error {
+	return errors.Join(s.txF.Close(), s.blkF.Close())
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 	"os"
@@ -11,23 +10,34 @@ import (
 )
 
 func main() {
-	// try load persisted store
-	if err := loadStore(); err != nil {
-		log.Printf("no persisted store loaded: %v", err)
-		// attempt to create an empty store.json so future saves have a valid target
-		p := persistedStore{TxStore: map[string]*Transaction{}, Chain: []Block{}}
-		if data, err := json.MarshalIndent(p, "", "  "); err == nil {
-			if err := os.WriteFile(storePath(), data, 0o644); err == nil {
-				log.Printf("created new empty store.json at %s", storePath())
-			} else {
-				log.Printf("failed to write empty store.json: %v", err)
-			}
-		} else {
-			log.Printf("failed to marshal empty store: %v", err)
+	// CLI modes (--string/--input/--estimate/--verify-offline/--verify-proof/--monitor/--bench-sim) run instead of the server
+	if handled, err := _maybeRunCLI(os.Args[1:]); handled {
+		if err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
+	// power-on self-test: без него /generate не обслуживается
+	if rep := runSelfTest(); rep.Passed {
+		log.Printf("selftest: %d known-answer tests passed", len(rep.Results))
 	} else {
-		log.Printf("loaded persisted store from %s", storePath())
+		for _, res := range rep.Results {
+			if !res.Passed {
+				log.Printf("selftest: FAILED %s: %s", res.Name, res.Error)
+			}
+		}
+		log.Printf("selftest: generation disabled until the self-test passes")
+	}
+	// load the snapshot and replay the log; appending on top of a store
+	// that didn't load would fork the chain
+	if err := openStore(); err != nil {
+		log.Fatalf("failed to open %s store: %v", storeBackend(), err)
+	}
+	if err := loadKeyring(store); err != nil {
+		log.Fatalf("failed to load keyring: %v", err)
 	}
+	ensureKeyring()
+	startTreeHeadPublisher(sthInterval())
 	mux := http.NewServeMux()
 	mux.HandleFunc("/generate", generateHandler)
 	mux.HandleFunc("/generate-tier", generateTierHandler)
@@ -35,6 +45,13 @@ func main() {
 	mux.HandleFunc("/txs", txsHandler)
 	mux.HandleFunc("/chain", chainHandler)
 	mux.HandleFunc("/stats/upload", uploadStatsHandler)
+	mux.HandleFunc("/entropy/estimate", entropyEstimateHandler)
+	mux.HandleFunc("/selftest", selfTestHandler)
+	mux.HandleFunc("/draw/", drawRouter)
+	mux.HandleFunc("/keys", keysHandler)
+	mux.HandleFunc("/keys/rotate", keysRotateHandler)
+	mux.HandleFunc("/log/sth", logSTHHandler)
+	mux.HandleFunc("/log/consistency", logConsistencyHandler)
 	c := cors.New(cors.Options{
 		AllowOriginFunc:  nil,
 		AllowedOrigins:   []string{"*"},
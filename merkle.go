@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Дерево Меркла по RFC 6962 §2.1 над блоками цепочки в порядке индексов.
+// Лист i — Block.Hash блока i (32 байта), он покрывает и DataHash
+// (published), и tx_id. Пустое дерево — SHA-256 от пустой строки.
+//   leaf: SHA-256(0x00 || block hash)
+//   node: SHA-256(0x01 || left || right)
+// Корень подписывается как signed tree head (STH), и доказательство
+// включения /tx/{id}/proof проверяется по нему без скачивания /chain.
+
+type merkleHash = [sha256.Size]byte
+
+func merkleLeafHash(data []byte) merkleHash {
+	return sha256.Sum256(append([]byte{0x00}, data...))
+}
+
+func merkleNodeHash(l, r merkleHash) merkleHash {
+	var buf [1 + 2*sha256.Size]byte
+	buf[0] = 0x01
+	copy(buf[1:], l[:])
+	copy(buf[1+sha256.Size:], r[:])
+	return sha256.Sum256(buf[:])
+}
+
+// splitPoint is the largest power of two smaller than n (n > 1).
+func splitPoint(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// merkleRoot is MTH(D[n]) over leaf hashes.
+func merkleRoot(leaves []merkleHash) merkleHash {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return merkleNodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merklePath is PATH(m, D[n]): sibling hashes from the leaf up.
+func merklePath(m int, leaves []merkleHash) []merkleHash {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if m < k {
+		return append(merklePath(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merklePath(m-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// merkleConsistency is PROOF(m, D[n]) (RFC 6962 §2.1.2), 0 < m <= n.
+func merkleConsistency(m int, leaves []merkleHash) []merkleHash {
+	return merkleSubproof(m, leaves, true)
+}
+
+func merkleSubproof(m int, leaves []merkleHash, complete bool) []merkleHash {
+	n := len(leaves)
+	if m == n {
+		if complete {
+			return nil
+		}
+		return []merkleHash{merkleRoot(leaves)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(merkleSubproof(m, leaves[:k], complete), merkleRoot(leaves[k:]))
+	}
+	return append(merkleSubproof(m-k, leaves[k:], false), merkleRoot(leaves[:k]))
+}
+
+// verifyConsistency checks that the tree of size second with root secondRoot
+// extends the tree of size first with root firstRoot (RFC 9162 §2.1.4.2).
+func verifyConsistency(first, second uint64, firstRoot, secondRoot merkleHash, proof []merkleHash) error {
+	switch {
+	case first == 0 || first > second:
+		return errors.New("merkle: bad tree sizes")
+	case first == second:
+		if len(proof) != 0 {
+			return errors.New("merkle: proof must be empty for equal sizes")
+		}
+		if firstRoot != secondRoot {
+			return errors.New("merkle: roots differ for equal sizes")
+		}
+		return nil
+	case len(proof) == 0:
+		return errors.New("merkle: empty consistency proof")
+	}
+	if first&(first-1) == 0 {
+		proof = append([]merkleHash{firstRoot}, proof...)
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errors.New("merkle: consistency proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = merkleNodeHash(c, fr)
+			sr = merkleNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = merkleNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("merkle: consistency proof too short")
+	}
+	if fr != firstRoot {
+		return errors.New("merkle: first root mismatch")
+	}
+	if sr != secondRoot {
+		return errors.New("merkle: second root mismatch")
+	}
+	return nil
+}
+
+// rootFromInclusion recomputes the root from a leaf hash and its audit path
+// (RFC 9162 §2.1.3.2). It fails if the path length doesn't fit (index, size).
+func rootFromInclusion(index, size uint64, leaf merkleHash, path []merkleHash) (merkleHash, error) {
+	if index >= size {
+		return merkleHash{}, errors.New("merkle: leaf index out of range")
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range path {
+		if sn == 0 {
+			return merkleHash{}, errors.New("merkle: audit path too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return merkleHash{}, errors.New("merkle: audit path too short")
+	}
+	return r, nil
+}
+
+// blockLeaf is the leaf hash of a block; Block.Hash is hex SHA-256.
+func blockLeaf(b Block) (merkleHash, error) {
+	raw, err := hex.DecodeString(b.Hash)
+	if err != nil || len(raw) != sha256.Size {
+		return merkleHash{}, fmt.Errorf("block %d: bad hash", b.Index)
+	}
+	return merkleLeafHash(raw), nil
+}
+
+// scanLeaves passes leaf hashes of blocks [from, to) to fn in order.
+func scanLeaves(from, to int, fn func(merkleHash)) error {
+	n := from
+	var err error
+	if err2 := scanBlocks(from, to, func(b Block) bool {
+		var l merkleHash
+		if l, err = blockLeaf(b); err != nil {
+			return false
+		}
+		fn(l)
+		n++
+		return true
+	}); err2 != nil {
+		return err2
+	}
+	if err != nil {
+		return err
+	}
+	if n != to {
+		return fmt.Errorf("chain has %d blocks, want %d", n, to)
+	}
+	return nil
+}
+
+// merkleCache keeps the roots of all complete subtrees of the chain:
+// levels[h][i] = MTH of leaves [i<<h, (i+1)<<h). The chain only grows, so
+// the cache is filled once and then extended by the new blocks; a root,
+// audit path or consistency proof then costs O(log n) hashes instead of
+// rereading the chain. Memory is about 64 bytes per block.
+type merkleCache struct {
+	mu     sync.Mutex
+	levels [][]merkleHash
+}
+
+var chainTree merkleCache
+
+// sync extends the cache to the first size blocks. Caller holds c.mu.
+func (c *merkleCache) sync(size int) error {
+	if len(c.levels) == 0 {
+		c.levels = [][]merkleHash{nil}
+	}
+	have := len(c.levels[0])
+	if size <= have {
+		return nil
+	}
+	return scanLeaves(have, size, c.add)
+}
+
+func (c *merkleCache) add(leaf merkleHash) {
+	c.levels[0] = append(c.levels[0], leaf)
+	for h := 0; len(c.levels[h])%2 == 0; h++ {
+		if h+1 == len(c.levels) {
+			c.levels = append(c.levels, nil)
+		}
+		lv := c.levels[h]
+		c.levels[h+1] = append(c.levels[h+1], merkleNodeHash(lv[len(lv)-2], lv[len(lv)-1]))
+	}
+}
+
+// root is MTH(D[lo:hi]). The RFC 6962 split keeps every complete subtree
+// aligned, so it is always in the cache.
+func (c *merkleCache) root(lo, hi int) merkleHash {
+	n := hi - lo
+	switch {
+	case n == 0:
+		return sha256.Sum256(nil)
+	case n&(n-1) == 0:
+		h := bits.TrailingZeros(uint(n))
+		return c.levels[h][lo>>h]
+	}
+	k := splitPoint(n)
+	return merkleNodeHash(c.root(lo, lo+k), c.root(lo+k, hi))
+}
+
+// path is merklePath(m, D[lo:hi]).
+func (c *merkleCache) path(m, lo, hi int) []merkleHash {
+	if hi-lo <= 1 {
+		return nil
+	}
+	k := splitPoint(hi - lo)
+	if m < k {
+		return append(c.path(m, lo, lo+k), c.root(lo+k, hi))
+	}
+	return append(c.path(m-k, lo+k, hi), c.root(lo, lo+k))
+}
+
+// subproof is merkleSubproof(m, D[lo:hi], complete).
+func (c *merkleCache) subproof(m, lo, hi int, complete bool) []merkleHash {
+	n := hi - lo
+	if m == n {
+		if complete {
+			return nil
+		}
+		return []merkleHash{c.root(lo, hi)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(c.subproof(m, lo, lo+k, complete), c.root(lo+k, hi))
+	}
+	return append(c.subproof(m-k, lo+k, hi, false), c.root(lo, lo+k))
+}
+
+// treeRoot is the root over the first size blocks.
+func treeRoot(size int) (merkleHash, error) {
+	chainTree.mu.Lock()
+	defer chainTree.mu.Unlock()
+	if err := chainTree.sync(size); err != nil {
+		return merkleHash{}, err
+	}
+	return chainTree.root(0, size), nil
+}
+
+// treeInclusion returns the leaf hash and audit path of leaf m in the tree
+// of the first size blocks.
+func treeInclusion(m, size int) (merkleHash, []merkleHash, error) {
+	chainTree.mu.Lock()
+	defer chainTree.mu.Unlock()
+	if err := chainTree.sync(size); err != nil {
+		return merkleHash{}, nil, err
+	}
+	return chainTree.levels[0][m], chainTree.path(m, 0, size), nil
+}
+
+// treeConsistency is PROOF(first, D[second]) over the chain.
+func treeConsistency(first, second int) ([]merkleHash, error) {
+	chainTree.mu.Lock()
+	defer chainTree.mu.Unlock()
+	if err := chainTree.sync(second); err != nil {
+		return nil, err
+	}
+	return chainTree.subproof(first, 0, second, true), nil
+}
+
+// SignedTreeHead is the signed root of the first TreeSize blocks.
+type SignedTreeHead struct {
+	TreeSize  uint64 `json:"tree_size"`
+	Timestamp int64  `json:"timestamp"` // unix ms
+	RootHash  string `json:"root_hash"` // hex
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+func sthMessage(h SignedTreeHead) []byte {
+	return []byte(fmt.Sprintf("%d:%d:%s", h.TreeSize, h.Timestamp, h.RootHash))
+}
+
+func signTreeHead(size uint64, root merkleHash) SignedTreeHead {
+	h := SignedTreeHead{TreeSize: size, Timestamp: time.Now().UnixMilli(), RootHash: hex.EncodeToString(root[:])}
+	k := activeSigner()
+	h.KeyID, h.Signature = k.ID, k.sign(sigCtxSTH, sthMessage(h))
+	return h
+}
+
+func (ks keySet) verifySTH(h SignedTreeHead) bool {
+	return ks.verify(sigCtxSTH, sthMessage(h), h.Signature, h.KeyID, time.UnixMilli(h.Timestamp))
+}
+
+// currentTreeHead signs the root over the whole chain.
+func currentTreeHead() (SignedTreeHead, error) {
+	size := chainLen()
+	root, err := treeRoot(size)
+	if err != nil {
+		return SignedTreeHead{}, err
+	}
+	return signTreeHead(uint64(size), root), nil
+}
+
+// InclusionProof is what /tx/{id}/proof returns.
+type InclusionProof struct {
+	TxID      string          `json:"tx_id"`
+	Block     Block           `json:"block"`
+	LeafIndex uint64          `json:"leaf_index"`
+	TreeSize  uint64          `json:"tree_size"`
+	LeafHash  string          `json:"leaf_hash"`
+	AuditPath []string        `json:"audit_path"`
+	STH       *SignedTreeHead `json:"sth,omitempty"`
+}
+
+// verifyInclusionProof checks the proof against a signed tree head: the STH
+// signature, the block hash, and the audit path up to STH.RootHash.
+func verifyInclusionProof(keys keySet, p InclusionProof, sth SignedTreeHead) error {
+	if !keys.verifySTH(sth) {
+		return errors.New("tree head signature does not verify")
+	}
+	if p.TreeSize != sth.TreeSize {
+		return fmt.Errorf("proof is for tree size %d, tree head has %d", p.TreeSize, sth.TreeSize)
+	}
+	if computeBlockHash(p.Block) != p.Block.Hash {
+		return errors.New("block hash mismatch")
+	}
+	if p.TxID != "" && p.Block.TxID != p.TxID {
+		return errors.New("block is for another tx")
+	}
+	if uint64(p.Block.Index) != p.LeafIndex {
+		return errors.New("leaf index differs from block index")
+	}
+	leaf, err := blockLeaf(p.Block)
+	if err != nil {
+		return err
+	}
+	path := make([]merkleHash, len(p.AuditPath))
+	for i, s := range p.AuditPath {
+		if path[i], err = decodeMerkleHash(s); err != nil {
+			return fmt.Errorf("audit path[%d]: %w", i, err)
+		}
+	}
+	root, err := rootFromInclusion(p.LeafIndex, p.TreeSize, leaf, path)
+	if err != nil {
+		return err
+	}
+	want, err := hex.DecodeString(sth.RootHash)
+	if err != nil || !bytes.Equal(root[:], want) {
+		return errors.New("root hash mismatch")
+	}
+	return nil
+}
+
+// GET /tx/{id}/proof[?tree_size=N] — путь аудита для блока, в котором
+// опубликована транзакция. Без tree_size — по последнему опубликованному
+// STH (он и прикладывается); с tree_size — по первым N блокам (для сверки
+// с ранее полученным STH).
+func txProof(w http.ResponseWriter, r *http.Request, id string) {
+	tx := mustTx(id, w)
+	if tx == nil {
+		return
+	}
+	txMutex.RLock()
+	published := tx.Published
+	txMutex.RUnlock()
+
+	var sth *SignedTreeHead
+	var size int
+	if s := r.URL.Query().Get("tree_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > chainLen() {
+			http.Error(w, "bad tree_size", http.StatusBadRequest)
+			return
+		}
+		size = n
+	} else {
+		h, err := treeHeadFor(id, published)
+		if err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(sthMinGap/time.Second)))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		sth, size = &h, int(h.TreeSize)
+	}
+	// у commit–reveal розыгрыша берём блок с результатом
+	blk, found, err := findTxBlock(id, published, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "tx not published in the first tree_size blocks", http.StatusNotFound)
+		return
+	}
+	leaf, path, err := treeInclusion(blk.Index, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p := InclusionProof{
+		TxID:      id,
+		Block:     blk,
+		LeafIndex: uint64(blk.Index),
+		TreeSize:  uint64(size),
+		LeafHash:  hex.EncodeToString(leaf[:]),
+		AuditPath: []string{},
+		STH:       sth,
+	}
+	for _, h := range path {
+		p.AuditPath = append(p.AuditPath, hex.EncodeToString(h[:]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// treeHeadFor returns the latest published head if it already covers the
+// tx's block. Otherwise it publishes a new one, but not more often than
+// sthMinGap: clients can't make the server sign on every request.
+func treeHeadFor(id, published string) (SignedTreeHead, error) {
+	sthMu.RLock()
+	latest := latestSTH
+	sthMu.RUnlock()
+	if latest != nil {
+		if _, found, err := findTxBlock(id, published, int(latest.TreeSize)); err != nil {
+			return SignedTreeHead{}, err
+		} else if found {
+			return *latest, nil
+		}
+		if time.Since(time.UnixMilli(latest.Timestamp)) < sthMinGap {
+			return SignedTreeHead{}, fmt.Errorf("tx is not in tree head %d yet, retry shortly", latest.TreeSize)
+		}
+	}
+	return publishTreeHead()
+}
+
+// Периодические tree heads: раз в STH_INTERVAL (по умолчанию минута)
+// сервер подписывает корень всей цепочки, /log/sth отдаёт последний.
+// Мониторы сохраняют увиденный head и требуют от следующего
+// доказательство согласованности (/log/consistency), см. monitor.go.
+var (
+	sthMu     sync.RWMutex
+	latestSTH *SignedTreeHead
+)
+
+const (
+	defaultSTHInterval = time.Minute
+	// sthMinGap: /tx/{id}/proof publishes a head early at most this often
+	sthMinGap = time.Second
+)
+
+func sthInterval() time.Duration {
+	if s := os.Getenv("STH_INTERVAL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("sth: bad STH_INTERVAL %q, using %s", s, defaultSTHInterval)
+	}
+	return defaultSTHInterval
+}
+
+// publishTreeHead signs the current root and makes it the latest head.
+func publishTreeHead() (SignedTreeHead, error) {
+	sth, err := currentTreeHead()
+	if err != nil {
+		return sth, err
+	}
+	sthMu.Lock()
+	// the ticker and /tx/{id}/proof may race; the head never goes back
+	if latestSTH == nil || sth.TreeSize >= latestSTH.TreeSize {
+		latestSTH = &sth
+	}
+	sthMu.Unlock()
+	return sth, nil
+}
+
+// startTreeHeadPublisher publishes a head now and then every interval.
+func startTreeHeadPublisher(interval time.Duration) {
+	if _, err := publishTreeHead(); err != nil {
+		log.Printf("sth: %v", err)
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if _, err := publishTreeHead(); err != nil {
+				log.Printf("sth: %v", err)
+			}
+		}
+	}()
+}
+
+// GET /log/sth — последний опубликованный подписанный корень дерева.
+func logSTHHandler(w http.ResponseWriter, r *http.Request) {
+	sthMu.RLock()
+	sth := latestSTH
+	sthMu.RUnlock()
+	if sth == nil {
+		h, err := publishTreeHead()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sth = &h
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(sth)
+}
+
+// ConsistencyProof is what /log/consistency returns.
+type ConsistencyProof struct {
+	First       uint64   `json:"first"`
+	Second      uint64   `json:"second"`
+	Consistency []string `json:"consistency"`
+}
+
+// GET /log/consistency?first=N[&second=M] — доказательство того, что
+// первые N блоков — префикс первых M (по умолчанию M — вся цепочка).
+// Корни клиент берёт из своих STH, в ответ они не входят.
+func logConsistencyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	second := chainLen()
+	if s := q.Get("second"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > second {
+			http.Error(w, "bad second", http.StatusBadRequest)
+			return
+		}
+		second = n
+	}
+	first, err := strconv.Atoi(q.Get("first"))
+	if err != nil || first < 1 || first > second {
+		http.Error(w, "bad first: need 1 <= first <= second", http.StatusBadRequest)
+		return
+	}
+	proof, err := treeConsistency(first, second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p := ConsistencyProof{First: uint64(first), Second: uint64(second), Consistency: []string{}}
+	for _, h := range proof {
+		p.Consistency = append(p.Consistency, hex.EncodeToString(h[:]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// verifySTHConsistency checks a consistency proof between two tree heads
+// whose signatures were already verified.
+func verifySTHConsistency(older, newer SignedTreeHead, p ConsistencyProof) error {
+	if p.First != older.TreeSize || p.Second != newer.TreeSize {
+		return fmt.Errorf("proof is for sizes %d..%d, heads are %d..%d", p.First, p.Second, older.TreeSize, newer.TreeSize)
+	}
+	r1, err := decodeMerkleHash(older.RootHash)
+	if err != nil {
+		return err
+	}
+	r2, err := decodeMerkleHash(newer.RootHash)
+	if err != nil {
+		return err
+	}
+	path := make([]merkleHash, len(p.Consistency))
+	for i, s := range p.Consistency {
+		if path[i], err = decodeMerkleHash(s); err != nil {
+			return fmt.Errorf("consistency[%d]: %w", i, err)
+		}
+	}
+	return verifyConsistency(p.First, p.Second, r1, r2, path)
+}
+
+func decodeMerkleHash(s string) (merkleHash, error) {
+	var h merkleHash
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != sha256.Size {
+		return h, errors.New("bad hash")
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// verifyProofCLI: --verify-proof <pubkey-hex|keys.json> <proof.json> [sth.json].
+// Without sth.json the tree head embedded in the proof is used.
+func verifyProofCLI(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: --verify-proof <pubkey-hex|keys.json> <proof.json> [sth.json]")
+	}
+	keys, err := loadKeySet(args[0])
+	if err != nil {
+		return err
+	}
+	var p InclusionProof
+	if err := readJSONFile(args[1], &p); err != nil {
+		return err
+	}
+	var sth SignedTreeHead
+	switch {
+	case len(args) >= 3:
+		if err := readJSONFile(args[2], &sth); err != nil {
+			return err
+		}
+	case p.STH != nil:
+		sth = *p.STH
+	default:
+		return errors.New("proof has no tree head; pass sth.json")
+	}
+	if err := verifyInclusionProof(keys, p, sth); err != nil {
+		return fmt.Errorf("proof does not verify: %w", err)
+	}
+	fmt.Printf("ok: tx %s is block %d in tree of size %d, root %s\n", p.TxID, p.LeafIndex, sth.TreeSize, sth.RootHash)
+	return nil
+}
+
+func readJSONFile(path string, v any) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
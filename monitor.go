@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Монитор журнала: go run . --monitor <server url> <state.json> [interval].
+// Хранит в state.json последний увиденный STH и известные ключи. На каждом
+// опросе берёт /keys и /log/sth, проверяет подпись head и доказательство
+// согласованности от сохранённого head к новому. Форк — дерево уменьшилось,
+// корень того же размера другой, доказательство не сходится или ключ с
+// известным ID сменился. При форке обе головы пишутся в state.json (поле
+// fork), алерт уходит в лог и POST'ом на MONITOR_WEBHOOK, если он задан,
+// а процесс завершается с ошибкой. Без interval — один опрос.
+
+type monitorState struct {
+	Server string          `json:"server"`
+	STH    *SignedTreeHead `json:"sth,omitempty"`
+	Keys   keySet          `json:"keys,omitempty"`
+	Fork   *monitorAlert   `json:"fork,omitempty"`
+}
+
+type monitorAlert struct {
+	Server     string          `json:"server"`
+	DetectedAt time.Time       `json:"detected_at"`
+	Reason     string          `json:"reason"`
+	Seen       *SignedTreeHead `json:"seen,omitempty"`
+	Got        *SignedTreeHead `json:"got,omitempty"`
+}
+
+// errFork marks monitor errors that are evidence of a rewritten log, as
+// opposed to the server being unreachable.
+var errFork = errors.New("log fork detected")
+
+func monitorGet(base, path string, v any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(base, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// mergeKeys adds keys the monitor hasn't seen; a known ID with another
+// public key is a fork.
+func (st *monitorState) mergeKeys(keys keySet) error {
+	for _, k := range keys {
+		if old := st.Keys.find(k.ID, ""); old != nil {
+			if old.Alg != k.Alg || old.PublicKey != k.PublicKey {
+				return fmt.Errorf("%w: key %s changed its public key", errFork, k.ID)
+			}
+			if old.RetiredAt == nil && k.RetiredAt != nil {
+				old.RetiredAt = k.RetiredAt
+			}
+			continue
+		}
+		kk := *k
+		st.Keys = append(st.Keys, &kk)
+	}
+	return nil
+}
+
+// monitorPoll runs one check and advances st.STH on success.
+func monitorPoll(st *monitorState) error {
+	var doc struct {
+		Keys keySet `json:"keys"`
+	}
+	if err := monitorGet(st.Server, "/keys", &doc); err != nil {
+		return err
+	}
+	if err := st.mergeKeys(doc.Keys); err != nil {
+		return err
+	}
+	var sth SignedTreeHead
+	if err := monitorGet(st.Server, "/log/sth", &sth); err != nil {
+		return err
+	}
+	if !st.Keys.verifySTH(sth) {
+		return fmt.Errorf("%w: tree head signature does not verify", errFork)
+	}
+	if old := st.STH; old != nil {
+		switch {
+		case sth.TreeSize < old.TreeSize:
+			return fmt.Errorf("%w: tree shrank from %d to %d", errFork, old.TreeSize, sth.TreeSize)
+		case sth.TreeSize == old.TreeSize:
+			if sth.RootHash != old.RootHash {
+				return fmt.Errorf("%w: two roots for tree size %d", errFork, sth.TreeSize)
+			}
+		case old.TreeSize > 0:
+			var p ConsistencyProof
+			if err := monitorGet(st.Server, fmt.Sprintf("/log/consistency?first=%d&second=%d", old.TreeSize, sth.TreeSize), &p); err != nil {
+				return err
+			}
+			if err := verifySTHConsistency(*old, sth, p); err != nil {
+				return fmt.Errorf("%w: %d -> %d: %v", errFork, old.TreeSize, sth.TreeSize, err)
+			}
+		}
+	}
+	st.STH = &sth
+	return nil
+}
+
+func monitorAlertHook(a monitorAlert) {
+	url := os.Getenv("MONITOR_WEBHOOK")
+	if url == "" {
+		return
+	}
+	body, _ := json.Marshal(a)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("monitor: webhook: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+func saveMonitorState(path string, st *monitorState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// monitorCLI: --monitor <server url> <state.json> [interval].
+func monitorCLI(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: --monitor <server url> <state.json> [interval, e.g. 1m]")
+	}
+	server, path := args[0], args[1]
+	var interval time.Duration
+	if len(args) >= 3 {
+		d, err := time.ParseDuration(args[2])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("bad interval %q", args[2])
+		}
+		interval = d
+	}
+	st := &monitorState{Server: server}
+	if _, err := os.Stat(path); err == nil {
+		if err := readJSONFile(path, st); err != nil {
+			return err
+		}
+		if st.Server != server {
+			return fmt.Errorf("%s is the state of %s, not %s", path, st.Server, server)
+		}
+	}
+	if st.Fork != nil {
+		return fmt.Errorf("%w earlier at %s: %s (see %s)", errFork, st.Fork.DetectedAt.Format(time.RFC3339), st.Fork.Reason, path)
+	}
+
+	for {
+		seen := st.STH
+		err := monitorPoll(st)
+		switch {
+		case errors.Is(err, errFork):
+			a := monitorAlert{Server: server, DetectedAt: time.Now().UTC(), Reason: err.Error(), Seen: seen}
+			var got SignedTreeHead
+			if monitorGet(server, "/log/sth", &got) == nil {
+				a.Got = &got
+			}
+			st.Fork = &a
+			log.Printf("monitor: ALERT %s: %v", server, err)
+			monitorAlertHook(a)
+			if err := saveMonitorState(path, st); err != nil {
+				log.Printf("monitor: %v", err)
+			}
+			return err
+		case err != nil:
+			// сервер недоступен — не форк, просто пропускаем опрос
+			if interval == 0 {
+				return err
+			}
+			log.Printf("monitor: %s: %v", server, err)
+		default:
+			if err := saveMonitorState(path, st); err != nil {
+				return err
+			}
+			log.Printf("monitor: %s ok, tree size %d, root %s", server, st.STH.TreeSize, st.STH.RootHash)
+		}
+		if interval == 0 {
+			return nil
+		}
+		time.Sleep(interval)
+	}
+}
@@ -5,9 +5,14 @@ import (
 	"crypto/cipher"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"math"
-	mrand "math/rand"
-	"strings"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type mover struct {
@@ -22,20 +27,93 @@ func (m *mover) record() { m.path = append(m.path, XY{m.x, m.y}) }
 // runSimulation: полностью детерминирована master-seed'ом и GenerateParams
 // Возвращает симуляцию и внутренний агрегированный хэш траектории (pathDigest)
 func runSimulation(seed int64, gp GenerateParams) (SimulationData, [32]byte) {
-	rnd := mrand.New(mrand.NewSource(seed))
+	return simulate(seed, gp, true)
+}
+
+// simulationDigest — тот же pathDigest без траекторий: позиции хэшируются по
+// ходу, память O(NumPoints), так что годятся и 10^7 итераций. Для всех, кому
+// не нужно рисовать (trng, verify, reproduce).
+func simulationDigest(seed int64, gp GenerateParams) [32]byte {
+	_, digest := simulate(seed, gp, false)
+	return digest
+}
+
+// simulate считает движение; при keepPaths=false траектории не копятся и
+// в SimulationData точки без Path.
+func simulate(seed int64, gp GenerateParams, keepPaths bool) (SimulationData, [32]byte) {
+	return simulateWith(seed, gp, keepPaths, simWorkers(gp.NumPoints))
+}
+
+// simulateWith раскладывает обновление точек внутри тика на workers горутин.
+// Порядок вызовов rnd и байт в хэше от workers не зависит, поэтому digest
+// тот же, что у последовательного прогона.
+func simulateWith(seed int64, gp GenerateParams, keepPaths bool, workers int) (SimulationData, [32]byte) {
+	s := newSimRun(seed, gp, keepPaths, workers)
+	defer s.close()
+	h := sha256.New()
+
+	// позиции тика пишутся в буфер по индексу точки и хэшируются одним Write —
+	// поток байт тот же, что при записи по точке. Хэш тика t считается в
+	// отдельной горутине, пока воркеры двигают точки тика t+1.
+	free := make(chan []byte, 2)
+	full := make(chan []byte, 2)
+	for i := 0; i < cap(free); i++ {
+		free <- make([]byte, 16*len(s.pts))
+	}
+	hashed := make(chan struct{})
+	go func() {
+		for b := range full {
+			h.Write(b)
+			free <- b
+		}
+		close(hashed)
+	}()
+
+	for t := 0; t < gp.Iterations; t++ {
+		buf := <-free
+		s.tick(t, buf)
+		full <- buf
+	}
+	close(full)
+	<-hashed
+
+	var digest [32]byte
+	copy(digest[:], h.Sum(nil))
+	return s.data(), digest
+}
+
+// simRun — прогон симуляции по тикам: simulateWith хэширует его, а
+// диагностика хаоса (chaos.go) ведёт рядом возмущённого близнеца.
+type simRun struct {
+	gp            GenerateParams
+	rnd           simRand
+	pts           []*mover
+	step          float64
+	sharp, smooth float64
+	choices       []string
+	laws          map[string]lawRun
+	mv            *moveParams
+	run           *moveRun
+}
+
+func newSimRun(seed int64, gp GenerateParams, keepPaths bool, workers int) *simRun {
+	rnd := newSimRand(gp.PRNG, seed, "")
 
 	// инициализация точек
 	colors := defaultColors()
 	pts := make([]*mover, 0, gp.NumPoints)
 	for i := 0; i < gp.NumPoints; i++ {
-		pts = append(pts, &mover{
+		p := &mover{
 			x:     rnd.Float64() * float64(gp.CanvasW),
 			y:     rnd.Float64() * float64(gp.CanvasH),
-			vx:    (rnd.Float64()*2 - 1) * (2 + gp.Motion.SpeedScale*2),
-			vy:    (rnd.Float64()*2 - 1) * (2 + gp.Motion.SpeedScale*2),
+			vx:    (float64(rnd.Float64()*2) - 1) * (2 + float64(gp.Motion.SpeedScale*2)),
+			vy:    (float64(rnd.Float64()*2) - 1) * (2 + float64(gp.Motion.SpeedScale*2)),
 			color: colors[i%len(colors)],
-			path:  make([]XY, 0, gp.Iterations),
-		})
+		}
+		if keepPaths {
+			p.path = make([]XY, 0, gp.Iterations)
+		}
+		pts = append(pts, p)
 	}
 
 	// подготовка шума
@@ -52,120 +130,74 @@ func runSimulation(seed int64, gp GenerateParams) (SimulationData, [32]byte) {
 	smooth := clamp(gp.Motion.Smoothness, 0, 2)
 	maxV := 20.0 * (0.5 + gp.Motion.SpeedScale)
 
-	h := sha256.New()
-
-	// prepare law choices: single law, comma-separated list, or "random" => all supported
-	var lawChoices []string
-	lawParam := strings.ToLower(gp.Motion.Law)
-	if lawParam == "random" || lawParam == "rand" {
-		lawChoices = []string{"flow", "sine", "jerk", "spiral"}
-	} else if strings.Contains(lawParam, ",") {
-		parts := strings.Split(lawParam, ",")
-		for i := range parts {
-			parts[i] = strings.TrimSpace(parts[i])
-			if parts[i] == "" {
-				parts = append(parts[:i], parts[i+1:]...)
-			}
+	// законы из реестра (motionlaw.go): single law, comma-separated list, or "random"
+	choices := lawChoices(gp.Motion.Law)
+	laws := make(map[string]lawRun, len(choices))
+	for _, name := range choices {
+		if _, ok := laws[name]; ok {
+			continue
 		}
-		if len(parts) == 0 {
-			parts = []string{"flow"}
-		}
-		lawChoices = parts
-	} else {
-		lawChoices = []string{lawParam}
+		laws[name] = lookupLaw(name).Start(&lawEnv{
+			w: gp.CanvasW, h: gp.CanvasH,
+			sharp: sharp, smooth: smooth,
+			noise:  n,
+			pts:    pts,
+			params: lawParams(gp.Motion, name),
+			rnd:    newSimRand(gp.PRNG, seed, name),
+		})
 	}
 
-	for t := 0; t < gp.Iterations; t++ {
-		timeOff := float64(t) * step
-		// choose law for this tick (deterministically via rnd)
-		law := lawChoices[0]
-		if len(lawChoices) > 1 {
-			law = lawChoices[rnd.Intn(len(lawChoices))]
-		}
-		for _, p := range pts {
-			ax, ay := 0.0, 0.0
-			switch law {
-			case "sine":
-				ax += math.Sin(timeOff+p.x*0.01) * (0.5 + smooth)
-				ay += math.Cos(timeOff+p.y*0.01) * (0.5 + smooth)
-			case "jerk":
-				// редкие импульсы
-				if rnd.Float64() < 0.02*(0.5+sharp) {
-					ang := rnd.Float64() * 2 * math.Pi
-					imp := 6.0 * (0.5 + sharp)
-					ax += imp * math.Cos(ang)
-					ay += imp * math.Sin(ang)
-				}
-			case "spiral":
-				cx, cy := float64(gp.CanvasW)/2, float64(gp.CanvasH)/2
-				dx, dy := cx-p.x, cy-p.y
-				ang := math.Atan2(dy, dx)
-				rad := (0.8 + smooth) * 1.2
-				tan := (0.5 + sharp) * 1.2
-				ax += rad*math.Cos(ang) - tan*math.Sin(ang)
-				ay += rad*math.Sin(ang) + tan*math.Cos(ang)
-			default: // flow / perlin-подобный
-				ax += n.noise2d(p.x*0.006+timeOff, p.y*0.006) * (1.0 + smooth)
-				ay += n.noise2d(p.y*0.006-timeOff, p.x*0.006) * (1.0 + smooth)
-			}
-
-			// апдейт скорости/позиции
-			p.vx = (p.vx + ax*(0.2+0.2*sharp)) * (0.98 + 0.01*smooth)
-			p.vy = (p.vy + ay*(0.2+0.2*sharp)) * (0.98 + 0.01*smooth)
-
-			vmag := math.Hypot(p.vx, p.vy)
-			if vmag > maxV {
-				scale := maxV / vmag
-				p.vx *= scale
-				p.vy *= scale
-			}
-
-			p.x += p.vx
-			p.y += p.vy
-
-			// отражение от границ
-			if p.x < 0 {
-				p.x = 0
-				p.vx = -p.vx
-			}
-			if p.y < 0 {
-				p.y = 0
-				p.vy = -p.vy
-			}
-			if p.x > float64(gp.CanvasW) {
-				p.x = float64(gp.CanvasW)
-				p.vx = -p.vx
-			}
-			if p.y > float64(gp.CanvasH) {
-				p.y = float64(gp.CanvasH)
-				p.vy = -p.vy
-			}
-
-			p.record()
+	mv := &moveParams{
+		w: gp.CanvasW, h: gp.CanvasH,
+		sharp: sharp, smooth: smooth, maxV: maxV,
+		ia: newInteractions(gp.Motion, gp.CanvasW, gp.CanvasH, len(pts)),
+	}
+	return &simRun{
+		gp: gp, rnd: rnd, pts: pts,
+		step: step, sharp: sharp, smooth: smooth,
+		choices: choices, laws: laws,
+		mv:  mv,
+		run: newMoveRun(mv, pts, workers, keepPaths),
+	}
+}
 
-			// include full float64 bits for more entropy in path digest
-			var tmp [16]byte
-			binary.LittleEndian.PutUint64(tmp[:8], mathFloat64bits(p.x))
-			binary.LittleEndian.PutUint64(tmp[8:], mathFloat64bits(p.y))
-			h.Write(tmp[:])
-		}
+// tick делает тик t; позиции точек после него — в buf (16 байт на точку).
+func (s *simRun) tick(t int, buf []byte) {
+	timeOff := float64(t) * s.step
+	// choose law for this tick (deterministically via rnd)
+	name := s.choices[0]
+	if len(s.choices) > 1 {
+		name = s.choices[s.rnd.Intn(len(s.choices))]
+	}
+	law := s.laws[name]
+	if p, ok := law.(lawPreparer); ok {
+		p.prepare(s.rnd, s.pts)
 	}
+	if s.mv.ia != nil {
+		s.mv.ia.prepare(s.pts)
+	}
+	s.run.tick(law, timeOff, buf)
+}
+
+func (s *simRun) close() { s.run.close() }
 
+func (s *simRun) data() SimulationData {
+	gp := s.gp
 	sim := SimulationData{
 		CanvasWidth:   gp.CanvasW,
 		CanvasHeight:  gp.CanvasH,
 		Iterations:    gp.Iterations,
-		Points:        make([]Point, 0, len(pts)),
-		Step:          step,
+		Points:        make([]Point, 0, len(s.pts)),
+		Step:          s.step,
 		MotionLaw:     gp.Motion.Law,
-		Sharpness:     sharp,
-		Smoothness:    smooth,
+		Sharpness:     s.sharp,
+		Smoothness:    s.smooth,
 		SpeedScale:    gp.Motion.SpeedScale,
 		EntropyMode:   gp.Entropy.Mode,
 		NumPoints:     gp.NumPoints,
 		DefaultColors: true,
 	}
-	for i, p := range pts {
+	for i, p := range s.pts {
 		sim.Points = append(sim.Points, Point{
 			ID:         i,
 			Color:      p.color,
@@ -173,9 +205,195 @@ func runSimulation(seed int64, gp GenerateParams) (SimulationData, [32]byte) {
 			Path:       p.path,
 		})
 	}
-	var digest [32]byte
-	copy(digest[:], h.Sum(nil))
-	return sim, digest
+	return sim
+}
+
+// moveParams — неизменяемые на время прогона параметры движения; step
+// читает только их, саму точку, состояние закона этой точки и снимок тика
+// для взаимодействий, поэтому точки тика можно двигать параллельно.
+type moveParams struct {
+	w, h                int
+	sharp, smooth, maxV float64
+	ia                  *interactions // nil — точки не взаимодействуют
+}
+
+// step двигает точку i на один тик по закону law.
+func (m *moveParams) step(i int, p *mover, law lawRun, timeOff float64) {
+	sharp, smooth := m.sharp, m.smooth
+	lax, lay := law.accel(i, p, timeOff)
+	// 0 + a, как было до реестра: -0 от закона становится +0, как в digest
+	// старых транзакций
+	ax, ay := 0.0, 0.0
+	ax += lax
+	ay += lay
+	if m.ia != nil {
+		if m.ia.collide {
+			m.ia.collideWith(i, p)
+		}
+		ix, iy := m.ia.accel(i)
+		ax += ix
+		ay += iy
+	}
+
+	// апдейт скорости/позиции; float64() вокруг произведений — против FMA
+	// (floatdet.go)
+	gain := 0.2 + float64(0.2*sharp)
+	damp := 0.98 + float64(0.01*smooth)
+	p.vx = float64((p.vx + float64(ax*gain)) * damp)
+	p.vy = float64((p.vy + float64(ay*gain)) * damp)
+
+	vmag := detHypot(p.vx, p.vy)
+	if vmag > m.maxV {
+		scale := m.maxV / vmag
+		p.vx = float64(p.vx * scale)
+		p.vy = float64(p.vy * scale)
+	}
+
+	p.x += p.vx
+	p.y += p.vy
+
+	// отражение от границ
+	if p.x < 0 {
+		p.x = 0
+		p.vx = -p.vx
+	}
+	if p.y < 0 {
+		p.y = 0
+		p.vy = -p.vy
+	}
+	if p.x > float64(m.w) {
+		p.x = float64(m.w)
+		p.vx = -p.vx
+	}
+	if p.y > float64(m.h) {
+		p.y = float64(m.h)
+		p.vy = -p.vy
+	}
+}
+
+// minPointsPerWorker: на меньших кусках синхронизация тика дороже самой работы.
+const minPointsPerWorker = 16
+
+// simWorkers — число воркеров для n точек: SIM_WORKERS или GOMAXPROCS.
+func simWorkers(n int) int {
+	w := runtime.GOMAXPROCS(0)
+	if s := os.Getenv("SIM_WORKERS"); s != "" {
+		if v, err := strconv.Atoi(s); err == nil && v > 0 {
+			w = v
+		}
+	}
+	return max(1, min(w, n/minPointsPerWorker))
+}
+
+type moveTick struct {
+	law     lawRun
+	timeOff float64
+	buf     []byte
+}
+
+// moveRun — пул воркеров одного прогона; каждый держит свой отрезок точек.
+type moveRun struct {
+	mv    *moveParams
+	pts   []*mover
+	keep  bool
+	work  []chan moveTick
+	bound []int
+	wg    sync.WaitGroup
+}
+
+func newMoveRun(mv *moveParams, pts []*mover, workers int, keep bool) *moveRun {
+	r := &moveRun{mv: mv, pts: pts, keep: keep}
+	if workers <= 1 {
+		return r
+	}
+	r.bound = make([]int, workers+1)
+	for i := range r.bound {
+		r.bound[i] = i * len(pts) / workers
+	}
+	r.work = make([]chan moveTick, workers)
+	for i := range r.work {
+		c := make(chan moveTick)
+		r.work[i] = c
+		lo, hi := r.bound[i], r.bound[i+1]
+		go func() {
+			for tk := range c {
+				r.move(lo, hi, tk)
+				r.wg.Done()
+			}
+		}()
+	}
+	return r
+}
+
+// tick двигает все точки и пишет их позиции в tk.buf (16 байт на точку:
+// float64-биты x и y, little-endian).
+func (r *moveRun) tick(law lawRun, timeOff float64, buf []byte) {
+	tk := moveTick{law: law, timeOff: timeOff, buf: buf}
+	if len(r.work) == 0 {
+		r.move(0, len(r.pts), tk)
+		return
+	}
+	r.wg.Add(len(r.work))
+	for _, c := range r.work {
+		c <- tk
+	}
+	r.wg.Wait()
+}
+
+func (r *moveRun) move(lo, hi int, tk moveTick) {
+	for i := lo; i < hi; i++ {
+		p := r.pts[i]
+		r.mv.step(i, p, tk.law, tk.timeOff)
+		if r.keep {
+			p.record()
+		}
+		// include full float64 bits for more entropy in path digest
+		binary.LittleEndian.PutUint64(tk.buf[i*16:], mathFloat64bits(p.x))
+		binary.LittleEndian.PutUint64(tk.buf[i*16+8:], mathFloat64bits(p.y))
+	}
+}
+
+func (r *moveRun) close() {
+	for _, c := range r.work {
+		close(c)
+	}
+}
+
+// benchSimCLI: --bench-sim [points] [iter] [workers] — digest-прогон одной
+// и той же симуляции последовательно и на пуле воркеров; печатает время,
+// ускорение и совпадение digest.
+func benchSimCLI(args []string) error {
+	points, iter := 200, 50_000
+	workers := max(1, min(runtime.GOMAXPROCS(0), points/minPointsPerWorker))
+	for i, dst := range []*int{&points, &iter, &workers} {
+		if len(args) > i {
+			v, err := strconv.Atoi(args[i])
+			if err != nil || v <= 0 {
+				return fmt.Errorf("bench-sim: bad argument %q", args[i])
+			}
+			*dst = v
+		}
+	}
+	gp := GenerateParams{
+		CanvasW: 1024, CanvasH: 1024, Iterations: iter, NumPoints: points, PixelWidth: 4, Step: 0.01,
+		Motion: MotionSpec{Law: "random", Sharpness: 1, Smoothness: 1, SpeedScale: 1},
+		PRNG:   simPRNGCurrent,
+	}
+	const seed = 20240601
+	t0 := time.Now()
+	_, serial := simulateWith(seed, gp, false, 1)
+	ts := time.Since(t0)
+	t0 = time.Now()
+	_, parallel := simulateWith(seed, gp, false, workers)
+	tp := time.Since(t0)
+	fmt.Printf("points=%d iter=%d GOMAXPROCS=%d\n", points, iter, runtime.GOMAXPROCS(0))
+	fmt.Printf("serial:     %v\n", ts.Round(time.Millisecond))
+	fmt.Printf("workers=%-3d %v (x%.2f)\n", workers, tp.Round(time.Millisecond), ts.Seconds()/tp.Seconds())
+	fmt.Printf("digest %x, match=%v\n", serial, serial == parallel)
+	if serial != parallel {
+		return errors.New("bench-sim: parallel digest differs from serial")
+	}
+	return nil
 }
 
 // извлекаем биты из pathDigest детерминированно: H(digest||ctr)
@@ -187,7 +405,7 @@ func expandBitsFromPathDigest(digest [32]byte, outBits int, mode string) []byte
 		// seed HMAC-DRBG once with the digest (legacy)
 		drbg := newHMACDRBG(digest[:])
 		needed := (outBits + 7) / 8
-		buf := drbg.Generate(needed)
+		buf := drbg.generate(needed, nil)
 		for _, b := range buf {
 			for bit := 7; bit >= 0 && used < outBits; bit-- {
 				out[used] = (b >> uint(bit)) & 1
@@ -373,7 +591,7 @@ func (s *simpleNoise) hashInt(x, y int64) int64 {
 }
 func (s *simpleNoise) val(ix, iy int64) float64 {
 	v := s.hashInt(ix, iy)
-	return float64(v&0x7fffffff)/float64(0x7fffffff)*2 - 1
+	return float64(float64(v&0x7fffffff)/float64(0x7fffffff)*2) - 1
 }
 func (s *simpleNoise) noise2d(x, y float64) float64 {
 	xi := int64(math.Floor(x))
@@ -384,11 +602,11 @@ func (s *simpleNoise) noise2d(x, y float64) float64 {
 	v10 := s.val(xi+1, yi)
 	v01 := s.val(xi, yi+1)
 	v11 := s.val(xi+1, yi+1)
-	sx := tx * tx * (3 - 2*tx)
-	sy := ty * ty * (3 - 2*ty)
-	ix0 := v00 + (v10-v00)*sx
-	ix1 := v01 + (v11-v01)*sx
-	return ix0 + (ix1-ix0)*sy
+	sx := tx * tx * (3 - float64(2*tx))
+	sy := ty * ty * (3 - float64(2*ty))
+	ix0 := v00 + float64((v10-v00)*sx)
+	ix1 := v01 + float64((v11-v01)*sx)
+	return ix0 + float64((ix1-ix0)*sy)
 }
 
 func mathFloat32(f float64) uint32 { return math.Float32bits(float32(f)) }
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MotionLaw — закон движения точек (параметр law=). Закон отдаёт ускорение
+// точки на тик; общая часть (затухание, ограничение скорости, отражение от
+// границ) — в moveParams.step. Свои параметры закон объявляет в Params, а
+// значения берутся из MotionSpec.Params по ключу "<закон>.<параметр>"
+// (lorenz.rho=28 в запросе /generate).
+type MotionLaw interface {
+	// Params — параметры закона со значениями по умолчанию (nil — нет своих).
+	Params() map[string]float64
+	// Start готовит закон к одному прогону.
+	Start(env *lawEnv) lawRun
+}
+
+// lawRun — закон в конкретном прогоне. accel вызывается параллельно для
+// разных точек и может писать только в состояние точки i.
+type lawRun interface {
+	accel(i int, p *mover, timeOff float64) (ax, ay float64)
+}
+
+// lawPreparer — закону нужен последовательный шаг в начале тика, до раздачи
+// точек воркерам: jerk тянет общий rnd, n-body снимает позиции всех точек.
+type lawPreparer interface {
+	prepare(rnd simRand, pts []*mover)
+}
+
+type lawEnv struct {
+	w, h          int
+	sharp, smooth float64
+	noise         *simpleNoise
+	pts           []*mover
+	params        map[string]float64
+	// собственный rnd закона (от seed и имени), общий rnd симуляции не трогает
+	rnd simRand
+}
+
+// Законы по имени. random выбирает только из исходных четырёх — иначе у
+// старых транзакций с law=random поменялся бы digest.
+var motionLaws = map[string]MotionLaw{
+	"flow":            flowLaw{},
+	"sine":            sineLaw{},
+	"jerk":            jerkLaw{},
+	"spiral":          spiralLaw{},
+	"lorenz":          lorenzLaw{},
+	"rossler":         rosslerLaw{},
+	"double-pendulum": pendulumLaw{},
+	"nbody":           nbodyLaw{},
+}
+
+var randomLaws = []string{"flow", "sine", "jerk", "spiral"}
+
+func motionLawNames() []string {
+	names := make([]string, 0, len(motionLaws))
+	for n := range motionLaws {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lawChoices разбирает MotionSpec.Law: один закон, список через запятую или
+// random.
+func lawChoices(law string) []string {
+	law = strings.ToLower(law)
+	if law == "random" || law == "rand" {
+		return randomLaws
+	}
+	if !strings.Contains(law, ",") {
+		return []string{law}
+	}
+	var parts []string
+	for _, p := range strings.Split(law, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		parts = []string{"flow"}
+	}
+	return parts
+}
+
+// lookupLaw: неизвестное имя — flow, как было до реестра.
+func lookupLaw(name string) MotionLaw {
+	if l, ok := motionLaws[name]; ok {
+		return l
+	}
+	return flowLaw{}
+}
+
+// lawParams — параметры закона name: значения по умолчанию, поверх —
+// записанные в spec.
+func lawParams(spec MotionSpec, name string) map[string]float64 {
+	out := map[string]float64{}
+	for k, v := range lookupLaw(name).Params() {
+		if sv, ok := spec.Params[name+"."+k]; ok {
+			v = sv
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// resolveMotionParams записывает в spec.Params все параметры выбранных
+// законов — из запроса или по умолчанию, чтобы прогон повторялся и после
+// смены умолчаний.
+func resolveMotionParams(spec *MotionSpec, q url.Values) error {
+	for _, name := range lawChoices(spec.Law) {
+		for k, v := range lookupLaw(name).Params() {
+			key := name + "." + k
+			if s := q.Get(key); s != "" {
+				f, err := strconv.ParseFloat(s, 64)
+				if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+					return fmt.Errorf("bad %s=%q", key, s)
+				}
+				v = f
+			}
+			if spec.Params == nil {
+				spec.Params = map[string]float64{}
+			}
+			spec.Params[key] = v
+		}
+	}
+	return nil
+}
+
+// lawSeed выводит seed собственного rnd закона из master-seed (для
+// math/rand; у chacha8-v1 свой вывод ключа, см. simrand.go).
+func lawSeed(seed int64, name string) int64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(seed))
+	h := sha256.Sum256(append([]byte("motion-law-v1\x00"+name+"\x00"), b[:]...))
+	return int64(binary.LittleEndian.Uint64(h[:8]))
+}
+
+// steer — ускорение, которое ведёт точку к цели (tx, ty): так законы со
+// своим фазовым пространством проецируются на холст.
+func steer(p *mover, tx, ty, pull float64) (float64, float64) {
+	return float64(pull*(tx-p.x)) - p.vx, float64(pull*(ty-p.y)) - p.vy
+}
+
+// --- исходные законы; формулы не менялись, digest старых транзакций тот же ---
+//
+// Здесь и ниже произведения, которые складываются, обёрнуты в float64(), а
+// sin/cos/atan2 — детерминированные копии из floatdet.go: иначе на arm64 и
+// других платформах с FMA digest разошёлся бы с amd64.
+
+type flowLaw struct{}
+
+func (flowLaw) Params() map[string]float64 { return nil }
+func (flowLaw) Start(env *lawEnv) lawRun   { return flowRun{env} }
+
+type flowRun struct{ env *lawEnv }
+
+// flow / perlin-подобный
+func (r flowRun) accel(_ int, p *mover, timeOff float64) (float64, float64) {
+	n, smooth := r.env.noise, r.env.smooth
+	return n.noise2d(float64(p.x*0.006)+timeOff, p.y*0.006) * (1.0 + smooth),
+		n.noise2d(float64(p.y*0.006)-timeOff, p.x*0.006) * (1.0 + smooth)
+}
+
+type sineLaw struct{}
+
+func (sineLaw) Params() map[string]float64 { return nil }
+func (sineLaw) Start(env *lawEnv) lawRun   { return sineRun{env} }
+
+type sineRun struct{ env *lawEnv }
+
+func (r sineRun) accel(_ int, p *mover, timeOff float64) (float64, float64) {
+	smooth := r.env.smooth
+	return detSin(timeOff+float64(p.x*0.01)) * (0.5 + smooth), detCos(timeOff+float64(p.y*0.01)) * (0.5 + smooth)
+}
+
+type jerkLaw struct{}
+
+func (jerkLaw) Params() map[string]float64 { return nil }
+func (jerkLaw) Start(env *lawEnv) lawRun {
+	return &jerkRun{env: env, ang: make([]float64, len(env.pts))}
+}
+
+// jerkRun: редкие импульсы. Углы тянутся из общего rnd в prepare по порядку
+// точек — так же, как раньше внутри цикла по точкам.
+type jerkRun struct {
+	env *lawEnv
+	ang []float64 // NaN — без импульса
+}
+
+func (r *jerkRun) prepare(rnd simRand, _ []*mover) {
+	for i := range r.ang {
+		r.ang[i] = math.NaN()
+		if rnd.Float64() < 0.02*(0.5+r.env.sharp) {
+			r.ang[i] = rnd.Float64() * 2 * math.Pi
+		}
+	}
+}
+
+func (r *jerkRun) accel(i int, _ *mover, _ float64) (float64, float64) {
+	if math.IsNaN(r.ang[i]) {
+		return 0, 0
+	}
+	imp := 6.0 * (0.5 + r.env.sharp)
+	return imp * detCos(r.ang[i]), imp * detSin(r.ang[i])
+}
+
+type spiralLaw struct{}
+
+func (spiralLaw) Params() map[string]float64 { return nil }
+func (spiralLaw) Start(env *lawEnv) lawRun   { return spiralRun{env} }
+
+type spiralRun struct{ env *lawEnv }
+
+func (r spiralRun) accel(_ int, p *mover, _ float64) (float64, float64) {
+	sharp, smooth := r.env.sharp, r.env.smooth
+	cx, cy := float64(r.env.w)/2, float64(r.env.h)/2
+	dx, dy := cx-p.x, cy-p.y
+	ang := detAtan2(dy, dx)
+	rad := (0.8 + smooth) * 1.2
+	tan := (0.5 + sharp) * 1.2
+	sin, cos := detSin(ang), detCos(ang)
+	return float64(rad*cos) - float64(tan*sin), float64(rad*sin) + float64(tan*cos)
+}
+
+// --- хаотические системы ---
+
+// vec3 — точка фазового пространства аттрактора.
+type vec3 [3]float64
+
+// rk4 — шаг Рунге–Кутты 4-го порядка для автономной системы f.
+func rk4(s vec3, dt float64, f func(vec3) vec3) vec3 {
+	add := func(a, b vec3, k float64) vec3 {
+		return vec3{a[0] + float64(b[0]*k), a[1] + float64(b[1]*k), a[2] + float64(b[2]*k)}
+	}
+	k1 := f(s)
+	k2 := f(add(s, k1, dt/2))
+	k3 := f(add(s, k2, dt/2))
+	k4 := f(add(s, k3, dt))
+	for j := range s {
+		s[j] += float64(dt / 6 * (k1[j] + float64(2*k2[j]) + float64(2*k3[j]) + k4[j]))
+	}
+	return s
+}
+
+// attractorRun — у каждой точки своя траектория аттрактора; точка следует
+// за её проекцией на холст.
+type attractorRun struct {
+	env   *lawEnv
+	state []vec3
+	f     func(vec3) vec3
+	dt    float64
+	pull  float64
+	// проекция: холст = центр + scale * (s[ix], s[iy] - off)
+	ix, iy int
+	off    float64
+	scale  float64
+}
+
+func (r *attractorRun) accel(i int, p *mover, _ float64) (float64, float64) {
+	s := rk4(r.state[i], r.dt, r.f)
+	r.state[i] = s
+	tx := float64(r.env.w)/2 + float64(r.scale*s[r.ix])
+	ty := float64(r.env.h)/2 - float64(r.scale*(s[r.iy]-r.off))
+	return steer(p, tx, ty, r.pull)
+}
+
+// lorenzLaw — аттрактор Лоренца, проекция (x, z).
+type lorenzLaw struct{}
+
+func (lorenzLaw) Params() map[string]float64 {
+	return map[string]float64{"sigma": 10, "rho": 28, "beta": 8.0 / 3, "dt": 0.01, "scale": 1, "pull": 0.2}
+}
+
+func (lorenzLaw) Start(env *lawEnv) lawRun {
+	pr := env.params
+	sigma, rho, beta := pr["sigma"], pr["rho"], pr["beta"]
+	r := &attractorRun{
+		env: env,
+		f: func(s vec3) vec3 {
+			return vec3{sigma * (s[1] - s[0]), float64(s[0]*(rho-s[2])) - s[1], float64(s[0]*s[1]) - float64(beta*s[2])}
+		},
+		dt: pr["dt"], pull: pr["pull"],
+		ix: 0, iy: 2, off: rho - 3,
+		scale: pr["scale"] * float64(min(env.w, env.h)) / 60,
+	}
+	for range env.pts {
+		r.state = append(r.state, vec3{
+			(float64(env.rnd.Float64()*2) - 1) * 15,
+			(float64(env.rnd.Float64()*2) - 1) * 15,
+			float64(env.rnd.Float64()*30) + 10,
+		})
+	}
+	return r
+}
+
+// rosslerLaw — аттрактор Рёсслера, проекция (x, y).
+type rosslerLaw struct{}
+
+func (rosslerLaw) Params() map[string]float64 {
+	return map[string]float64{"a": 0.2, "b": 0.2, "c": 5.7, "dt": 0.05, "scale": 1, "pull": 0.2}
+}
+
+func (rosslerLaw) Start(env *lawEnv) lawRun {
+	pr := env.params
+	a, b, c := pr["a"], pr["b"], pr["c"]
+	r := &attractorRun{
+		env: env,
+		f: func(s vec3) vec3 {
+			return vec3{-s[1] - s[2], s[0] + float64(a*s[1]), b + float64(s[2]*(s[0]-c))}
+		},
+		dt: pr["dt"], pull: pr["pull"],
+		ix: 0, iy: 1,
+		scale: pr["scale"] * float64(min(env.w, env.h)) / 30,
+	}
+	for range env.pts {
+		r.state = append(r.state, vec3{
+			(float64(env.rnd.Float64()*2) - 1) * 10,
+			(float64(env.rnd.Float64()*2) - 1) * 10,
+			env.rnd.Float64(),
+		})
+	}
+	return r
+}
+
+// pendulumLaw — двойной маятник с подвесом в центре холста; точка следует
+// за концом второго звена.
+type pendulumLaw struct{}
+
+func (pendulumLaw) Params() map[string]float64 {
+	return map[string]float64{"g": 9.81, "l1": 1, "l2": 1, "m1": 1, "m2": 1, "dt": 0.02, "pull": 0.2}
+}
+
+func (pendulumLaw) Start(env *lawEnv) lawRun {
+	pr := env.params
+	r := &pendulumRun{
+		env: env,
+		g:   pr["g"], l1: pr["l1"], l2: pr["l2"], m1: pr["m1"], m2: pr["m2"],
+		dt: pr["dt"], pull: pr["pull"],
+	}
+	if reach := math.Abs(r.l1) + math.Abs(r.l2); reach > 0 {
+		r.scale = 0.45 * float64(min(env.w, env.h)) / reach
+	}
+	for range env.pts {
+		// (θ1, θ2, ω1, ω2)
+		r.state = append(r.state, [4]float64{
+			(float64(env.rnd.Float64()*2) - 1) * math.Pi,
+			(float64(env.rnd.Float64()*2) - 1) * math.Pi,
+			float64(env.rnd.Float64()*2) - 1,
+			float64(env.rnd.Float64()*2) - 1,
+		})
+	}
+	return r
+}
+
+type pendulumRun struct {
+	env               *lawEnv
+	g, l1, l2, m1, m2 float64
+	dt, pull, scale   float64
+	state             [][4]float64
+}
+
+// deriv — уравнения Лагранжа двойного маятника.
+func (r *pendulumRun) deriv(s [4]float64) [4]float64 {
+	t1, t2, w1, w2 := s[0], s[1], s[2], s[3]
+	g, l1, l2, m1, m2 := r.g, r.l1, r.l2, r.m1, r.m2
+	d := t1 - t2
+	sd, cd := detSin(d), detCos(d)
+	den := float64(2*m1) + m2 - float64(m2*detCos(2*d))
+	a1 := (float64(-g*(float64(2*m1)+m2)*detSin(t1)) - float64(m2*g*detSin(t1-float64(2*t2))) -
+		float64(2*sd*m2*(float64(w2*w2*l2)+float64(w1*w1*l1*cd)))) / (l1 * den)
+	a2 := 2 * sd * (float64(w1*w1*l1*(m1+m2)) + float64(g*(m1+m2)*detCos(t1)) + float64(w2*w2*l2*m2*cd)) / (l2 * den)
+	return [4]float64{w1, w2, a1, a2}
+}
+
+func (r *pendulumRun) accel(i int, p *mover, _ float64) (float64, float64) {
+	s, dt := r.state[i], r.dt
+	add := func(a, b [4]float64, k float64) [4]float64 {
+		for j := range a {
+			a[j] += float64(b[j] * k)
+		}
+		return a
+	}
+	k1 := r.deriv(s)
+	k2 := r.deriv(add(s, k1, dt/2))
+	k3 := r.deriv(add(s, k2, dt/2))
+	k4 := r.deriv(add(s, k3, dt))
+	for j := range s {
+		s[j] += float64(dt / 6 * (k1[j] + float64(2*k2[j]) + float64(2*k3[j]) + k4[j]))
+	}
+	r.state[i] = s
+	tx := float64(r.env.w)/2 + float64(r.scale*(float64(r.l1*detSin(s[0]))+float64(r.l2*detSin(s[1]))))
+	ty := float64(r.env.h)/2 + float64(r.scale*(float64(r.l1*detCos(s[0]))+float64(r.l2*detCos(s[1]))))
+	return steer(p, tx, ty, r.pull)
+}
+
+// nbodyLaw — гравитация между точками со смягчением: a = G·m·d/(|d|²+ε²)^1.5.
+// Все точки тика притягиваются к позициям на его начало (снимок в prepare),
+// так что результат не зависит от порядка и числа воркеров.
+type nbodyLaw struct{}
+
+func (nbodyLaw) Params() map[string]float64 {
+	return map[string]float64{"g": 2000, "mass": 1, "softening": 20}
+}
+
+func (nbodyLaw) Start(env *lawEnv) lawRun {
+	pr := env.params
+	return &nbodyRun{
+		gm:   pr["g"] * pr["mass"],
+		eps2: pr["softening"] * pr["softening"],
+		pos:  make([]XY, len(env.pts)),
+	}
+}
+
+type nbodyRun struct {
+	gm, eps2 float64
+	pos      []XY
+}
+
+func (r *nbodyRun) prepare(_ simRand, pts []*mover) {
+	for i, p := range pts {
+		r.pos[i] = XY{p.x, p.y}
+	}
+}
+
+func (r *nbodyRun) accel(i int, _ *mover, _ float64) (float64, float64) {
+	var ax, ay float64
+	me := r.pos[i]
+	for j, o := range r.pos {
+		if j == i {
+			continue
+		}
+		dx, dy := o.X-me.X, o.Y-me.Y
+		d2 := float64(dx*dx) + float64(dy*dy) + r.eps2
+		if d2 == 0 {
+			continue
+		}
+		k := r.gm / (d2 * math.Sqrt(d2))
+		ax += float64(k * dx)
+		ay += float64(k * dy)
+	}
+	return ax, ay
+}
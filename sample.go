@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// Выборки поверх любого источника uint64 (TRNG и т.п.). Алгоритмы
+// зафиксированы здесь, а не взяты из math/rand/v2: розыгрыши должны
+// воспроизводиться из seed независимо от версии Go.
+
+// uint64n returns a uniform value in [0, n) by Lemire's multiply-and-reject
+// method ("Fast Random Integer Generation in an Interval", 2019). n > 0.
+func uint64n(src rand.Source, n uint64) uint64 {
+	hi, lo := bits.Mul64(src.Uint64(), n)
+	if lo < n {
+		thresh := -n % n
+		for lo < thresh {
+			hi, lo = bits.Mul64(src.Uint64(), n)
+		}
+	}
+	return hi
+}
+
+// intN returns a uniform int in [0, n). It panics if n <= 0.
+func intN(src rand.Source, n int) int {
+	if n <= 0 {
+		panic("invalid argument to IntN")
+	}
+	return int(uint64n(src, uint64(n)))
+}
+
+// float64From returns a uniform float64 in [0, 1) from the top 53 bits.
+func float64From(src rand.Source) float64 {
+	return float64(src.Uint64()>>11) * 0x1.0p-53
+}
+
+// shuffle is Fisher–Yates from the top index down, j = intN(i+1).
+func shuffle(src rand.Source, n int, swap func(i, j int)) {
+	if n < 0 {
+		panic("invalid argument to Shuffle")
+	}
+	for i := n - 1; i > 0; i-- {
+		swap(i, intN(src, i+1))
+	}
+}
+
+// perm returns a random permutation of [0, n).
+func perm(src rand.Source, n int) []int {
+	p := make([]int, n)
+	for i := range p {
+		p[i] = i
+	}
+	shuffle(src, n, func(i, j int) { p[i], p[j] = p[j], p[i] })
+	return p
+}
+
+// normFloat64 is a standard normal variate (Marsaglia polar method; the
+// second value of each pair is discarded so there is no hidden state).
+func normFloat64(src rand.Source) float64 {
+	for {
+		u := 2*float64From(src) - 1
+		v := 2*float64From(src) - 1
+		s := u*u + v*v
+		if s > 0 && s < 1 {
+			return u * math.Sqrt(-2*math.Log(s)/s)
+		}
+	}
+}
+
+// expFloat64 is an exponential variate with rate 1 (inversion).
+func expFloat64(src rand.Source) float64 {
+	return -math.Log(1 - float64From(src))
+}
+
+// shuffleMod is the original tier shuffle: j = Uint64 % (i+1). It is
+// biased for pool sizes that aren't powers of two and is kept only to
+// verify draws recorded before draw_algorithm existed.
+func shuffleMod(src rand.Source, n int, swap func(i, j int)) {
+	for i := n - 1; i > 0; i-- {
+		swap(i, int(src.Uint64()%uint64(i+1)))
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Power-on self-test: known-answer тесты всех DRBG (NIST CAVP), HMAC (RFC 4231)
+// и эталонные pathDigest симуляции запускаются в main() до старта сервера. Пока они не пройдены, /generate
+// и /generate-tier отвечают 503; отчёт отдаётся на /selftest.
+
+// drbgKAT is one CAVP DRBG vector: instantiate, optionally reseed, then two
+// Generate calls; the output of the second must equal returned.
+type drbgKAT struct {
+	mech             string // "" = hmac-sha256
+	name             string
+	entropy          string
+	nonce            string
+	pers             string
+	entropyReseed    string // only for vectors with an explicit reseed
+	additionalReseed string
+	additional       [2]string
+	returned         string
+}
+
+// drbgVectors: PredictionResistance = False, one COUNT per group
+// (CAVP drbgvectors_no_reseed / drbgvectors_pr_false).
+var drbgVectors = []drbgKAT{
+	{
+		name:       "HMAC_DRBG SHA-256 no_reseed pers=0 add=0",
+		entropy:    "ca851911349384bffe89de1cbdc46e6831e44d34a4fb935ee285dd14b71a7488",
+		nonce:      "659ba96c601dc69fc902940805ec0ca8",
+		pers:       "",
+		additional: [2]string{"", ""},
+		returned:   "e528e9abf2dece54d47c7e75e5fe302149f817ea9fb4bee6f4199697d04d5b89d54fbb978a15b5c443c9ec21036d2460b6f73ebad0dc2aba6e624abf07745bc107694bb7547bb0995f70de25d6b29e2d3011bb19d27676c07162c8b5ccde0668961df86803482cb37ed6d5c0bb8d50cf1f50d476aa0458bdaba806f48be9dcb8",
+	},
+	{
+		name:       "HMAC_DRBG SHA-256 no_reseed pers=0 add=256",
+		entropy:    "d3cc4d1acf3dde0c4bd2290d262337042dc632948223d3a2eaab87da44295fbd",
+		nonce:      "0109b0e729f457328aa18569a9224921",
+		pers:       "",
+		additional: [2]string{"3c311848183c9a212a26f27f8c6647e40375e466a0857cc39c4e47575d53f1f6", "fcb9abd19ccfbccef88c9c39bfb3dd7b1c12266c9808992e305bc3cff566e4e4"},
+		returned:   "9c7b758b212cd0fcecd5daa489821712e3cdea4467b560ef5ddc24ab47749a1f1ffdbbb118f4e62fcfca3371b8fbfc5b0646b83e06bfbbab5fac30ea09ea2bc76f1ea568c9be0444b2cc90517b20ca825f2d0eccd88e7175538b85d90ab390183ca6395535d34473af6b5a5b88f5a59ee7561573337ea819da0dcc3573a22974",
+	},
+	{
+		name:       "HMAC_DRBG SHA-256 no_reseed pers=256 add=0",
+		entropy:    "5cacc68165a2e2ee20812f35ec73a79dbf30fd475476ac0c44fc6174cdac2b55",
+		nonce:      "6f885496c1e63af620becd9e71ecb824",
+		pers:       "e72dd8590d4ed5295515c35ed6199e9d211b8f069b3058caa6670b96ef1208d0",
+		additional: [2]string{"", ""},
+		returned:   "f1012cf543f94533df27fedfbf58e5b79a3dc517a9c402bdbfc9a0c0f721f9d53faf4aafdc4b8f7a1b580fcaa52338d4bd95f58966a243cdcd3f446ed4bc546d9f607b190dd69954450d16cd0e2d6437067d8b44d19a6af7a7cfa8794e5fbd728e8fb2f2e8db5dd4ff1aa275f35886098e80ff844886060da8b1e7137846b23b",
+	},
+	{
+		name:       "HMAC_DRBG SHA-256 no_reseed pers=256 add=256",
+		entropy:    "5d3286bc53a258a53ba781e2c4dcd79a790e43bbe0e89fb3eed39086be34174b",
+		nonce:      "c5422294b7318952ace7055ab7570abf",
+		pers:       "2dba094d008e150d51c4135bb2f03dcde9cbf3468a12908a1b025c120c985b9d",
+		additional: [2]string{"793a7ef8f6f0482beac542bb785c10f8b7b406a4de92667ab168ecc2cf7573c6", "2238cdb4e23d629fe0c2a83dd8d5144ce1a6229ef41dabe2a99ff722e510b530"},
+		returned:   "d04678198ae7e1aeb435b45291458ffde0891560748b43330eaf866b5a6385e74c6fa5a5a44bdb284d436e98d244018d6acedcdfa2e9f499d8089e4db86ae89a6ab2d19cb705e2f048f97fb597f04106a1fa6a1416ad3d859118e079a0c319eb95686f4cbcce3b5101c7a0b010ef029c4ef6d06cdfac97efb9773891688c37cf",
+	},
+	{
+		name:             "HMAC_DRBG SHA-256 reseed pers=256 add=256",
+		entropy:          "cdb0d9117cc6dbc9ef9dcb06a97579841d72dc18b2d46a1cb61e314012bdf416",
+		nonce:            "d0c0d01d156016d0eb6b7e9c7c3c8da8",
+		pers:             "6f0fb9eab3f9ea7ab0a719bfa879bf0aaed683307fda0c6d73ce018b6e34faaa",
+		entropyReseed:    "8ec6f7d5a8e2e88f43986f70b86e050d07c84b931bcf18e601c5a3eee3064c82",
+		additionalReseed: "1ab4ca9014fa98a55938316de8ba5a68c629b0741bdd058c4d70c91cda5099b3",
+		additional:       [2]string{"16e2d0721b58d839a122852abd3bf2c942a31c84d82fca74211871880d7162ff", "53686f042a7b087d5d2eca0d2a96de131f275ed7151189f7ca52deaa78b79fb2"},
+		returned:         "dda04a2ca7b8147af1548f5d086591ca4fd951a345ce52b3cd49d47e84aa31a183e31fbc42a1ff1d95afec7143c8008c97bc2a9c091df0a763848391f68cb4a366ad89857ac725a53b303ddea767be8dc5f605b1b95f6d24c9f06be65a973a089320b3cc42569dcfd4b92b62a993785b0301b3fc452445656fce22664827b88f",
+	},
+	{
+		mech:       "ctr-aes256",
+		name:       "CTR_DRBG AES-256 no_reseed pers=0 add=0",
+		entropy:    "36401940fa8b1fba91a1661f211d78a0b9389a74e5bccfece8d766af1a6d3b14",
+		nonce:      "496f25b0f1301b4f501be30380a137eb",
+		pers:       "",
+		additional: [2]string{"", ""},
+		returned:   "5862eb38bd558dd978a696e6df164782ddd887e7e9a6c9f3f1fbafb78941b535a64912dfd224c6dc7454e5250b3d97165e16260c2faf1cc7735cb75fb4f07e1d",
+	},
+	{
+		mech:             "ctr-aes256",
+		name:             "CTR_DRBG AES-256 reseed pers=256 add=256",
+		entropy:          "174b46250051a9e3d80c56ae7163dafe7e54481a56cafd3b8625f99bbb29c442",
+		nonce:            "98ffd99c466e0e94a45da7e0e82dbc6b",
+		pers:             "7095268e99938b3e042734b9176c9aa051f00a5f8d2a89ada214b89beef18ebf",
+		entropyReseed:    "e88be1967c5503f65d23867bbc891bd679db03b4878663f6c877592df25f0d9a",
+		additionalReseed: "cdf6ad549e45b6aa5cd67d024931c33cd133d52d5ae500c3015020beb30da063",
+		additional:       [2]string{"c7228e90c62f896a09e11684530102f926ec90a3255f6c21b857883c75800143", "76a94f224178fe4cbf9e2b8acc53c9dc3e50bb613aac8936601453cda3293b17"},
+		returned:         "1a6d8dbd642076d13916e5e23038b60b26061f13dd4e006277e0268698ffb2c87e453bae1251631ac90c701a9849d933995e8b0221fe9aca1985c546c2079027",
+	},
+	{
+		mech:       "hash-sha256",
+		name:       "Hash_DRBG SHA-256 no_reseed pers=0 add=0",
+		entropy:    "a65ad0f345db4e0effe875c3a2e71f42c7129d620ff5c119a9ef55f05185e0fb",
+		nonce:      "8581f9317517276e06e9607ddbcbcc2e",
+		pers:       "",
+		additional: [2]string{"", ""},
+		returned:   "d3e160c35b99f340b2628264d1751060e0045da383ff57a57d73a673d2b8d80daaf6a6c35a91bb4579d73fd0c8fed111b0391306828adfed528f018121b3febdc343e797b87dbb63db1333ded9d1ece177cfa6b71fe8ab1da46624ed6415e51ccde2c7ca86e283990eeaeb91120415528b2295910281b02dd431f4c9f70427df",
+	},
+	{
+		mech:             "hash-sha256",
+		name:             "Hash_DRBG SHA-256 reseed pers=256 add=256",
+		entropy:          "6c623aea73bc8a59e28c6cd9c7c7ec8ca2e75190bd5dcae5978cf0c199c23f4f",
+		nonce:            "e55db067a0ed537e66886b7cda02f772",
+		pers:             "1e59d798810083d1ff848e90b25c9927e3dfb55a0888b0339566a9f9ca7542dc",
+		entropyReseed:    "9ab40164744c7d00c78b4196f6f917ec33d70030a0812cd4606c5a25387568a9",
+		additionalReseed: "4e8bead7cbba7a7bc9ae1e1617222c4139661347599950e7225d1e2faa5d57f5",
+		additional:       [2]string{"dcb22a5d9f149858636f3ede2253e419816fb7b1103194451ed6a573a8fe6271", "8f9d5c78cdabc32e71ac3b3c49239caddf96053250f4fd92056efbd0be487d36"},
+		returned:         "6e98a3b1f686f6ffa79355c9d8a5ab7f93312159d52659a2298315f10007c71adabc0b5ccb4164c0949fbdb221b43acdb62bed3099596f2d7bd5d0048173dd2360a543b234ab61a441ddb9299af84ca45c6e618fd521366dbf509d4ec06174da924361d642b107e5564ac1b32340dd2f3158bf4c00bcb4dcf12c6d67af4b74ee",
+	},
+	{
+		mech:       "hash-sha512",
+		name:       "Hash_DRBG SHA-512 no_reseed pers=0 add=0",
+		entropy:    "6b50a7d8f8a55d7a3df8bb40bcc3b722d8708de67fda010b03c4c84d72096f8c",
+		nonce:      "3ec649cc6256d9fa31db7a2904aaf025",
+		pers:       "",
+		additional: [2]string{"", ""},
+		returned:   "95b7f17e9802d3577392c6a9c08083b67dd1292265b5f42d237f1c55bb9b10bfcfd82c77a378b8266a0099143b3c2d64611eeeb69acdc055957c139e8b190c7a06955f2c797c2778de940396a501f40e91396acf8d7e45ebdbb53bbf8c975230d2f0ff9106c76119ae498e7fbc03d90f8e4c51627aed5c8d4263d5d2b978873a0de596ee6dc7f7c29e37eee8b34c90dd1cf6a9ddb22b4cbd086b14b35de93da2d5cb1806698cbd7bbb67bfe3d31fd2d1dbd2a1e058a3eb99d7e51f1a938eed5e1c1de23a6b4345d3191409f92f39b3670d8dbfb635d8e6a36932d81033d1448d63b403ddf88e121b6e819ac381226c1321e4b08644f6727c368c5a9f7a4b3ee2",
+	},
+	{
+		mech:             "hash-sha512",
+		name:             "Hash_DRBG SHA-512 reseed pers=256 add=256",
+		entropy:          "4b23595b0a3640cfabb0ec34df6a613308b0448488a5d9ff99da4278e072eb34",
+		nonce:            "8e696bffd9ca3a71d2e2f05e600c8364",
+		pers:             "010ba93ea68a3d4a200e5145859e299c5b5349b7645fb5bbcad687aba7d67313",
+		entropyReseed:    "04de4babdbe143bde99aa4452f9aa43b0a164eb927555c0496aa0fc9328a521c",
+		additionalReseed: "2b0c7c3efb36b71b917a44086d168313675b426b17c5ab3d0eb6af753f6040e0",
+		additional:       [2]string{"d0b7d1d12ab15d3bba8f4eba07fee0974838962b247be480683b8e3d4a91033a", "66c78ca12e45bdca003b49cb6440b977dd85b167e7c803890ed1a73666eaa869"},
+		returned:         "4008cbd8281dc82fd6c368f650ef2609bb771e80c63d478a77fa938248dcbb8b79e54ead0265f6ff1ebfafe4e387c6e27df9f03e4a5225e86a4436e56ebf03b3be2cfbcb49c89c92ec1dfa5ee445dd4f6f64e02a2423a0b18ebd02eec52f5cc21bc3565e796b3ded6552f1b5a574a201c3b11018222806f9618d23d77fd02db879cf87fe24ed7ba11b3b108b559633db1f95c5121b28011aa4dd20399bd4978e1f8b8880c333a47ff1750679bf28d329347b26d347aae90ee562ae8029579cbe0336e066d6b8ba5e0169fec804c30189a4434c1bf8a5b0a249951d3d89554da38ff0751b8b1fef9ae18a0aa2bc477736d199a06f61d400039a4cc03869bb10ca",
+	},
+}
+
+// hmacKAT is an RFC 4231 test case for HMAC-SHA-256. mac may be truncated
+// (test case 5 checks only the first 128 bits).
+type hmacKAT struct {
+	name string
+	key  string
+	data string
+	mac  string
+}
+
+var rfc4231Vectors = []hmacKAT{
+	{
+		name: "RFC 4231 test case 1",
+		key:  "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+		data: "4869205468657265",
+		mac:  "b0344c61d8db38535ca8afceaf0bf12b881dc200c9833da726e9376c2e32cff7",
+	},
+	{
+		name: "RFC 4231 test case 2",
+		key:  "4a656665",
+		data: "7768617420646f2079612077616e7420666f72206e6f7468696e673f",
+		mac:  "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843",
+	},
+	{
+		name: "RFC 4231 test case 3",
+		key:  "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		data: "dddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddddd",
+		mac:  "773ea91e36800e46854db8ebd09181a72959098b3ef8c122d9635514ced565fe",
+	},
+	{
+		name: "RFC 4231 test case 4",
+		key:  "0102030405060708090a0b0c0d0e0f10111213141516171819",
+		data: "cdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcdcd",
+		mac:  "82558a389a443c0ea4cc819899f2083a85f0faa3e578f8077a2e3ff46729665b",
+	},
+	{
+		name: "RFC 4231 test case 5",
+		key:  "0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c0c",
+		data: "546573742057697468205472756e636174696f6e",
+		mac:  "a3b6167473100ee06e0c796c2955552b",
+	},
+	{
+		name: "RFC 4231 test case 6",
+		key:  "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		data: "54657374205573696e67204c6172676572205468616e20426c6f636b2d53697a65204b6579202d2048617368204b6579204669727374",
+		mac:  "60e431591ee0b67f0d8a26aacbf5b77f8e0bc6213728c5140546040f0ee37f54",
+	},
+	{
+		name: "RFC 4231 test case 7",
+		key:  "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		data: "5468697320697320612074657374207573696e672061206c6172676572207468616e20626c6f636b2d73697a65206b657920616e642061206c6172676572207468616e20626c6f636b2d73697a6520646174612e20546865206b6579206e6565647320746f20626520686173686564206265666f7265206265696e6720757365642062792074686520484d414320616c676f726974686d2e",
+		mac:  "9b09ffa71b942fcb27635fbcd5b0e944bfdc63644f0713938a7f51535c3a35e2",
+	},
+}
+
+// simKAT — эталонный pathDigest короткой симуляции в режиме strict-v1
+// (floatdet.go) с генератором prng (simrand.go). Digest посчитан на amd64;
+// если сборка для другой архитектуры или версия Go с ним не сходится, её
+// транзакции не проверялись бы в других местах, и генерация выключается.
+type simKAT struct {
+	name   string
+	prng   string
+	points int
+	motion MotionSpec
+	digest string
+}
+
+// simVectors: холст 256×256, 300 тиков, seed 20240601, sharp = smooth =
+// speed = 1, параметры законов по умолчанию. Векторы на 64 точки идут и
+// через пул из нескольких воркеров (moveRun), как в проде.
+var simVectors = []simKAT{
+	{"simulation strict-v1 math/rand flow", simPRNGLegacy, 8, MotionSpec{Law: "flow"}, "1d7a0091edfe55034daf3c93ac7a164a8426f39bf5a0a870bb561826b06db0b0"},
+	{"simulation strict-v1 math/rand sine", simPRNGLegacy, 8, MotionSpec{Law: "sine"}, "c192618734ecb8f12319b1cbe8edef5e1f1683330f787c65e52d165e5adbad7c"},
+	{"simulation strict-v1 math/rand jerk", simPRNGLegacy, 8, MotionSpec{Law: "jerk"}, "fda6e1cf1382c4307d6dbf3f830c0e40c23bb1738071ea5bbffd02a9ae68205a"},
+	{"simulation strict-v1 math/rand spiral", simPRNGLegacy, 8, MotionSpec{Law: "spiral"}, "0aa557374b60d9b70338b5090927d4643f2eb48686319e57ec967d01041faf1d"},
+	{"simulation strict-v1 math/rand random", simPRNGLegacy, 8, MotionSpec{Law: "random"}, "a0ab047729d9b8306658c790e83afcf094362f5951a642b07548f30776bb375c"},
+	{"simulation strict-v1 math/rand lorenz", simPRNGLegacy, 8, MotionSpec{Law: "lorenz"}, "ed311014e60ab11665cb4d2e1b9cbfaba22dd20edc4dac5dd248505212325fa0"},
+	{"simulation strict-v1 math/rand rossler", simPRNGLegacy, 8, MotionSpec{Law: "rossler"}, "b69966a799f7d82402f19ca8a0b9e1dca686efb5cb30e83242bed0875ed41224"},
+	{"simulation strict-v1 math/rand double-pendulum", simPRNGLegacy, 8, MotionSpec{Law: "double-pendulum"}, "5b6b655d984b46faee00ce06d09b658a52be9e741e70c27f3e5ca959d5022227"},
+	{"simulation strict-v1 math/rand nbody", simPRNGLegacy, 8, MotionSpec{Law: "nbody"}, "055fefde7b0b49f881ec25788fb3475f6aac3fdf0e2d9033e2f2f006f010d210"},
+	{"simulation strict-v1 math/rand random+interactions", simPRNGLegacy, 8, MotionSpec{Law: "random", Collide: true, Attract: -0.5, Flock: 1}, "278af849dcfbfa3829a3fc8f27738f246ea877d7a25ccc92d4d72ab6729a6ddd"},
+	{"simulation strict-v1 chacha8-v1 flow", simPRNGChaCha8, 8, MotionSpec{Law: "flow"}, "eebaacb648b2ece9c8a9fbd784530c12d268e680f0a7b769ec63e63d4bb250fa"},
+	{"simulation strict-v1 chacha8-v1 sine", simPRNGChaCha8, 8, MotionSpec{Law: "sine"}, "bed17753e89214e69da541f713944fce12bba25c80adbe4d2372db9c1487e820"},
+	{"simulation strict-v1 chacha8-v1 jerk", simPRNGChaCha8, 8, MotionSpec{Law: "jerk"}, "5dcb1b52cb0ad7c15661fd3178e54d84b1425025545f7c90f75f9ccd1f609c09"},
+	{"simulation strict-v1 chacha8-v1 spiral", simPRNGChaCha8, 8, MotionSpec{Law: "spiral"}, "2251b0b88fa03f8bccbaeef772a8a98a1811ce9e2ce3756e240e3c16b5b9724a"},
+	{"simulation strict-v1 chacha8-v1 random", simPRNGChaCha8, 8, MotionSpec{Law: "random"}, "6ca79866d36e1d97a64c7daaf523be8526ae5c31d97430cced7f3bf198ddb15f"},
+	{"simulation strict-v1 chacha8-v1 lorenz", simPRNGChaCha8, 8, MotionSpec{Law: "lorenz"}, "f818f1961690d7bba1715db3e5f647eb0aa5d9b81cc1c3f83162642ce43232c4"},
+	{"simulation strict-v1 chacha8-v1 rossler", simPRNGChaCha8, 8, MotionSpec{Law: "rossler"}, "44ad45148713942a5a7c6a4fa3864f2b7019a398341cf54d7d9ee128418774f6"},
+	{"simulation strict-v1 chacha8-v1 double-pendulum", simPRNGChaCha8, 8, MotionSpec{Law: "double-pendulum"}, "5a75b26cbb3a3d92dd92bc9494d166c8f295be77427836ec770c6bb124ec8f9d"},
+	{"simulation strict-v1 chacha8-v1 nbody", simPRNGChaCha8, 8, MotionSpec{Law: "nbody"}, "35a49d8f730c45070fce110346949e3427c09a1232380dd67435d9c0b25ec8af"},
+	{"simulation strict-v1 chacha8-v1 random+interactions", simPRNGChaCha8, 8, MotionSpec{Law: "random", Collide: true, Attract: -0.5, Flock: 1}, "00d73ed883c1f8290be190d942fdf98abc1eedf346338f2d159e7939e53d8bc8"},
+	{"simulation strict-v1 math/rand flow 64 points", simPRNGLegacy, 64, MotionSpec{Law: "flow"}, "d5d8e8daf4ead698917dde21183a55ca3fd3c6537478892f8841f17c93a52b41"},
+	{"simulation strict-v1 chacha8-v1 nbody 64 points", simPRNGChaCha8, 64, MotionSpec{Law: "nbody"}, "6bcc24fe746d6f62068d3df84e18047007ecb1140fd18b07b352ca6118150d08"},
+	{"simulation strict-v1 chacha8-v1 random+interactions 64 points", simPRNGChaCha8, 64, MotionSpec{Law: "random", Collide: true, Attract: -0.5, Flock: 1}, "d8591a867b8971c3f7f58305dd741c0ea6777ec4e64c2277e7d947b8b5b817e1"},
+}
+
+type SelfTestResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+type SelfTestReport struct {
+	Passed  bool             `json:"passed"`
+	RanAt   time.Time        `json:"ran_at"`
+	Results []SelfTestResult `json:"results"`
+}
+
+var (
+	selfTestMu     sync.RWMutex
+	selfTestReport SelfTestReport // zero value: not passed until runSelfTest
+)
+
+// runSelfTest runs every vector, stores the report and returns it.
+func runSelfTest() SelfTestReport {
+	rep := SelfTestReport{Passed: true, RanAt: time.Now().UTC()}
+	add := func(name string, err error) {
+		res := SelfTestResult{Name: name, Passed: err == nil}
+		if err != nil {
+			res.Error = err.Error()
+			rep.Passed = false
+		}
+		rep.Results = append(rep.Results, res)
+	}
+	for _, v := range drbgVectors {
+		add(v.name, checkDRBG(v))
+	}
+	for _, v := range rfc4231Vectors {
+		add(v.name, checkHMAC(v))
+	}
+	for _, v := range simVectors {
+		add(v.name, checkSimulation(v))
+	}
+
+	selfTestMu.Lock()
+	selfTestReport = rep
+	selfTestMu.Unlock()
+	return rep
+}
+
+func selfTestPassed() bool {
+	selfTestMu.RLock()
+	defer selfTestMu.RUnlock()
+	return selfTestReport.Passed
+}
+
+func checkDRBG(v drbgKAT) error {
+	d, err := InstantiateDRBG(v.mech, mustHex(v.entropy), mustHex(v.nonce), mustHex(v.pers))
+	if err != nil {
+		return err
+	}
+	if v.entropyReseed != "" {
+		if err := d.Reseed(mustHex(v.entropyReseed), mustHex(v.additionalReseed)); err != nil {
+			return err
+		}
+	}
+	want := mustHex(v.returned)
+	if _, err := d.Generate(len(want), mustHex(v.additional[0])); err != nil {
+		return err
+	}
+	got, err := d.Generate(len(want), mustHex(v.additional[1]))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("output mismatch: got %x", got[:16])
+	}
+	return nil
+}
+
+// checkHMAC проверяет самописный hmacSHA256 из motion.go.
+func checkHMAC(v hmacKAT) error {
+	want := mustHex(v.mac)
+	got := hmacSHA256(mustHex(v.key), mustHex(v.data))
+	if !bytes.Equal(got[:len(want)], want) {
+		return fmt.Errorf("mac mismatch: got %x", got)
+	}
+	return nil
+}
+
+// checkSimulation пересчитывает pathDigest вектора.
+func checkSimulation(v simKAT) error {
+	spec := v.motion
+	spec.Sharpness, spec.Smoothness, spec.SpeedScale = 1, 1, 1
+	if err := resolveMotionParams(&spec, nil); err != nil {
+		return err
+	}
+	resolveInteractions(&spec)
+	gp := GenerateParams{CanvasW: 256, CanvasH: 256, Iterations: 300, NumPoints: v.points, Step: 0.01, Motion: spec, PRNG: v.prng}
+	// последовательно и пулом воркеров — независимо от GOMAXPROCS машины
+	pools := []int{1}
+	if w := v.points / minPointsPerWorker; w > 1 {
+		pools = append(pools, w)
+	}
+	for _, workers := range pools {
+		_, digest := simulateWith(20240601, gp, false, workers)
+		if got := hex.EncodeToString(digest[:]); got != v.digest {
+			return fmt.Errorf("digest mismatch with %d workers: got %s", workers, got)
+		}
+	}
+	return nil
+}
+
+// mustHex decodes an embedded vector; a typo there is a programming error.
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("selftest: bad hex in vector: " + err.Error())
+	}
+	return b
+}
+
+// requireSelfTest отвечает 503 и возвращает false, если self-test не пройден.
+func requireSelfTest(w http.ResponseWriter) bool {
+	if selfTestPassed() {
+		return true
+	}
+	http.Error(w, "self-test failed, generation disabled (see /selftest)", http.StatusServiceUnavailable)
+	return false
+}
+
+// GET /selftest — отчёт последнего прогона; ?run=1 перезапускает тесты.
+func selfTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var rep SelfTestReport
+	if r.URL.Query().Get("run") == "1" {
+		rep = runSelfTest()
+		if !rep.Passed {
+			log.Printf("selftest: FAILED on re-run")
+		}
+	} else {
+		selfTestMu.RLock()
+		rep = selfTestReport
+		selfTestMu.RUnlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !rep.Passed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(rep)
+}
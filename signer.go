@@ -0,0 +1,457 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ed25519-подписи транзакций, блоков и tier-розыгрышей. В отличие от
+// HMAC их проверяет кто угодно по публичному ключу с /keys, в том числе
+// офлайн: `rng-chaos --verify-offline <pubkey-hex|keys.json> <file>`.
+//
+// Подписываемые сообщения (каждое с префиксом контекста):
+//   tx:     "rng-chaos tx v1\n"     || JSON транзакции без simulation и tx_signature
+//   block:  "rng-chaos block v1\n"  || Block.Hash
+//   tier:   "rng-chaos tier v1\n"   || tierPayload (см. draw.go)
+//   rotate: "rng-chaos rotate v1\n" || new key_id || "\n" || new public key (hex)
+//   sth:    "rng-chaos sth v1\n"    || tree_size ":" timestamp ":" root_hash (merkle.go)
+//
+// Ключи лежат в связке (keyring) с ID, временем активации и вывода из
+// оборота. Каждая подпись несёт key_id; проверка берёт ключ по ID и
+// требует, чтобы на момент подписи он ещё не был выведен из оборота.
+// Подписи без key_id сделаны до появления связки: tier HMAC — ключом
+// "hmac-sha256", прочие — первым Ed25519-ключом.
+
+const (
+	sigAlgHMAC    = "" // legacy tier signatures: HMAC-SHA256
+	sigAlgEd25519 = "ed25519"
+	keyAlgHMAC    = "hmac-sha256"
+	hmacKeySize   = 32 // the legacy tier HMAC key was always 32 random bytes
+
+	sigCtxTx     = "rng-chaos tx v1\n"
+	sigCtxBlock  = "rng-chaos block v1\n"
+	sigCtxTier   = "rng-chaos tier v1\n"
+	sigCtxRotate = "rng-chaos rotate v1\n"
+	sigCtxSTH    = "rng-chaos sth v1\n"
+)
+
+// signingKeyEntry is one keyring key. secret (Ed25519 private key or HMAC
+// key) is never served; in store.json it is sealed like the old signing_key.
+type signingKeyEntry struct {
+	ID          string     `json:"id"`
+	Alg         string     `json:"alg"`
+	PublicKey   string     `json:"public_key,omitempty"` // hex, ed25519 only
+	ActivatedAt time.Time  `json:"activated_at"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+
+	secret []byte
+	sealed string // secret as read from store.json when it could not be opened
+}
+
+type keySet []*signingKeyEntry
+
+var (
+	signerMu sync.RWMutex
+	keyring  keySet // entries are replaced on retirement, never mutated
+)
+
+func keyIDFor(alg string, material []byte) string {
+	h := sha256.Sum256(append([]byte(alg+":"), material...))
+	return hex.EncodeToString(h[:8])
+}
+
+func newEd25519Entry(priv ed25519.PrivateKey, at time.Time) *signingKeyEntry {
+	pub := priv.Public().(ed25519.PublicKey)
+	return &signingKeyEntry{
+		ID:          keyIDFor(sigAlgEd25519, pub),
+		Alg:         sigAlgEd25519,
+		PublicKey:   hex.EncodeToString(pub),
+		ActivatedAt: at.UTC(),
+		secret:      priv,
+	}
+}
+
+func generateEd25519Entry(at time.Time) *signingKeyEntry {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return newEd25519Entry(priv, at)
+}
+
+// active is the newest Ed25519 key that is not retired and whose secret is
+// available (a key sealed under another passphrase still verifies).
+func (ks keySet) active() *signingKeyEntry {
+	for i := len(ks) - 1; i >= 0; i-- {
+		if ks[i].Alg == sigAlgEd25519 && ks[i].RetiredAt == nil && len(ks[i].secret) > 0 {
+			return ks[i]
+		}
+	}
+	return nil
+}
+
+// find returns the key by ID; id == "" means a signature made before key IDs
+// existed, which belongs to the first key of that algorithm.
+func (ks keySet) find(id, alg string) *signingKeyEntry {
+	for _, k := range ks {
+		if (id != "" && k.ID == id) || (id == "" && k.Alg == alg) {
+			return k
+		}
+	}
+	return nil
+}
+
+// validAt: the key had not been retired at t. Activation is informational:
+// a tx created just before a rotation may be signed by the new key.
+func (k *signingKeyEntry) validAt(t time.Time) bool {
+	return k.RetiredAt == nil || t.Before(*k.RetiredAt)
+}
+
+// verify checks an Ed25519 signature made by key id at time at. The time
+// window is only enforced for signatures that carry a key_id.
+func (ks keySet) verify(ctx string, msg []byte, sigHex, id string, at time.Time) bool {
+	k := ks.find(id, sigAlgEd25519)
+	if k == nil || k.Alg != sigAlgEd25519 || (id != "" && !k.validAt(at)) {
+		return false
+	}
+	pub, err := hex.DecodeString(k.PublicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(pub, append([]byte(ctx), msg...), sig)
+}
+
+func currentKeys() keySet {
+	signerMu.RLock()
+	defer signerMu.RUnlock()
+	return append(keySet(nil), keyring...)
+}
+
+// ensureKeyring creates an Ed25519 key on first start, so /keys doesn't
+// change across restarts before the first block is written.
+func ensureKeyring() {
+	k := activeSigner()
+	log.Printf("ed25519 key %s: %s", k.ID, k.PublicKey)
+}
+
+// activeSigner returns the key new signatures are made with, creating (and
+// persisting) one if the keyring has none.
+func activeSigner() *signingKeyEntry {
+	signerMu.RLock()
+	k := keyring.active()
+	signerMu.RUnlock()
+	if k != nil {
+		return k
+	}
+	signerMu.Lock()
+	created := false
+	if k = keyring.active(); k == nil {
+		k = generateEd25519Entry(time.Now())
+		keyring = append(keyring, k)
+		created = true
+	}
+	signerMu.Unlock()
+	if created {
+		persistKeyring()
+	}
+	return k
+}
+
+func (k *signingKeyEntry) sign(ctx string, msg []byte) string {
+	return hex.EncodeToString(ed25519.Sign(k.secret, append([]byte(ctx), msg...)))
+}
+
+// txSigningBytes: the transaction as stored (no simulation), minus its own signature.
+func txSigningBytes(tx *Transaction) []byte {
+	c := *tx
+	c.Sim = SimulationData{}
+	c.TxSignature = ""
+	b, _ := json.Marshal(&c)
+	return b
+}
+
+// txSignedAt: a commit–reveal draw is re-signed at reveal, everything else at creation.
+func txSignedAt(tx *Transaction) time.Time {
+	if tx.Draw != nil && tx.Draw.RevealedAt != nil {
+		return *tx.Draw.RevealedAt
+	}
+	return tx.CreatedAt
+}
+
+// signTx (re)signs the whole transaction; called from appendBlock, so every
+// state that gets a block is signed. Caller must not hold txMutex.
+func signTx(tx *Transaction) {
+	k := activeSigner()
+	txMutex.Lock()
+	defer txMutex.Unlock()
+	tx.TxKeyID = k.ID // part of the signed bytes
+	tx.TxSignature = k.sign(sigCtxTx, txSigningBytes(tx))
+}
+
+func (ks keySet) verifyTx(tx *Transaction) bool {
+	return ks.verify(sigCtxTx, txSigningBytes(tx), tx.TxSignature, tx.TxKeyID, txSignedAt(tx))
+}
+
+func signBlock(b *Block) {
+	k := activeSigner()
+	b.KeyID, b.Signature = k.ID, k.sign(sigCtxBlock, []byte(b.Hash))
+}
+
+func (ks keySet) verifyBlock(b Block) bool {
+	return ks.verify(sigCtxBlock, []byte(b.Hash), b.Signature, b.KeyID, time.Unix(b.Timestamp, 0))
+}
+
+// signTier sets the tier signature (Ed25519 over tierPayload) on tx.
+func signTier(tx *Transaction) {
+	k := activeSigner()
+	tx.SignatureAlg, tx.KeyID = sigAlgEd25519, k.ID
+	tx.Signature = k.sign(sigCtxTier, tierPayload(tx))
+}
+
+// verifyTier checks tx.Signature with the algorithm it was made with. For
+// legacy HMAC signatures expected is the recomputed MAC ("" if the HMAC key
+// is not in the keyring, e.g. offline).
+func (ks keySet) verifyTier(tx *Transaction) (ok bool, expected string) {
+	if tx.SignatureAlg == sigAlgEd25519 {
+		return ks.verify(sigCtxTier, tierPayload(tx), tx.Signature, tx.KeyID, txSignedAt(tx)), ""
+	}
+	k := ks.find(tx.KeyID, keyAlgHMAC)
+	if k == nil || k.Alg != keyAlgHMAC || len(k.secret) == 0 {
+		return false, ""
+	}
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(tierPayload(tx))
+	expected = fmt.Sprintf("%x", mac.Sum(nil))
+	return expected == tx.Signature, expected
+}
+
+func tierSignatureAlg(tx *Transaction) string {
+	if tx.SignatureAlg == sigAlgHMAC {
+		return keyAlgHMAC
+	}
+	return tx.SignatureAlg
+}
+
+func rotationMessage(kr *KeyRotation) []byte {
+	return []byte(kr.KeyID + "\n" + kr.PublicKey)
+}
+
+// verifyRotation: the previous key vouches for the new one. It signs just
+// before it is retired, so it is checked as of that moment. A rotation
+// without prev_key_id is only valid if no other Ed25519 key was active then;
+// otherwise dropping the endorsement would be enough to slip a key in.
+func (ks keySet) verifyRotation(kr *KeyRotation) bool {
+	before := kr.ActivatedAt.Add(-time.Nanosecond)
+	if kr.PrevKeyID == "" {
+		for _, k := range ks {
+			if k.Alg == sigAlgEd25519 && k.ID != kr.KeyID && !k.ActivatedAt.After(before) && k.validAt(before) {
+				return false
+			}
+		}
+		return true
+	}
+	return ks.verify(sigCtxRotate, rotationMessage(kr), kr.PrevSignature, kr.PrevKeyID, before)
+}
+
+// rotateKey retires the active key and activates a fresh one. The new key
+// and the old key's signature over it are published as a chain block.
+func rotateKey(reason string) (*Transaction, *signingKeyEntry, error) {
+	now := time.Now().UTC()
+	next := generateEd25519Entry(now)
+	kr := &KeyRotation{KeyID: next.ID, Alg: next.Alg, PublicKey: next.PublicKey, ActivatedAt: now, Reason: reason}
+
+	signerMu.Lock()
+	if old := keyring.active(); old != nil {
+		kr.PrevKeyID, kr.PrevSignature = old.ID, old.sign(sigCtxRotate, rotationMessage(kr))
+	}
+	for i, k := range keyring {
+		if k.Alg == sigAlgEd25519 && k.RetiredAt == nil {
+			retired := *k
+			retired.RetiredAt = &now
+			keyring[i] = &retired
+		}
+	}
+	keyring = append(keyring, next)
+	signerMu.Unlock()
+	persistKeyring()
+
+	b, _ := json.Marshal(kr)
+	h := sha256.Sum256(b)
+	tx := &Transaction{
+		TxID:        newUUID(),
+		CreatedAt:   now,
+		Published:   hex.EncodeToString(h[:]),
+		KeyRotation: kr,
+	}
+	if err := appendBlock(tx); err != nil {
+		return nil, nil, err
+	}
+	return tx, next, nil
+}
+
+// GET /keys — вся связка ключей (без секретов) для проверки подписей.
+func keysHandler(w http.ResponseWriter, r *http.Request) {
+	keys := currentKeys()
+	out := map[string]any{
+		"keys":     keys,
+		"contexts": map[string]string{"tx": sigCtxTx, "block": sigCtxBlock, "tier": sigCtxTier, "rotate": sigCtxRotate, "sth": sigCtxSTH},
+	}
+	if k := keys.active(); k != nil {
+		out["active_key_id"] = k.ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+// POST /keys/rotate — админская операция, нужен заголовок
+// "Authorization: Bearer $ADMIN_TOKEN". Без ADMIN_TOKEN ротация выключена.
+func keysRotateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		http.Error(w, "key rotation disabled: ADMIN_TOKEN not set", http.StatusForbidden)
+		return
+	}
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !hmac.Equal([]byte(got), []byte(token)) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	tx, k, err := rotateKey(req.Reason)
+	if err != nil {
+		// the new key is active and persisted, only its announcement failed
+		http.Error(w, "store: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("keys: rotated to %s (tx=%s)", k.ID, tx.TxID)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"tx_id":        tx.TxID,
+		"key":          k,
+		"key_rotation": tx.KeyRotation,
+	})
+}
+
+// loadKeySet: a hex public key (one key, also used for signatures without
+// key_id) or a file with the /keys response.
+func loadKeySet(arg string) (keySet, error) {
+	if pub, err := hex.DecodeString(arg); err == nil {
+		if len(pub) != ed25519.PublicKeySize {
+			return nil, errors.New("public key must be 64 hex chars")
+		}
+		return keySet{{ID: keyIDFor(sigAlgEd25519, pub), Alg: sigAlgEd25519, PublicKey: arg}}, nil
+	}
+	raw, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Keys keySet `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil || len(doc.Keys) == 0 {
+		return nil, fmt.Errorf("%s: not a /keys document", arg)
+	}
+	return doc.Keys, nil
+}
+
+// verifyOfflineCLI: --verify-offline <pubkey-hex|keys.json> <file>. file is
+// store.json, a /tx/{id}/info response or a /chain response; only public
+// keys are used.
+func verifyOfflineCLI(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: --verify-offline <pubkey-hex|keys.json> <store.json|tx-info.json|chain.json>")
+	}
+	keys, err := loadKeySet(args[0])
+	if err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		TxStore map[string]*Transaction `json:"tx_store"`
+		Chain   []Block                 `json:"chain"`
+		Tx      *Transaction            `json:"tx"`
+	}
+	var txs []*Transaction
+	var blocks []Block
+	if err := json.Unmarshal(raw, &doc); err == nil && (doc.TxStore != nil || doc.Tx != nil || doc.Chain != nil) {
+		for _, tx := range doc.TxStore {
+			txs = append(txs, tx)
+		}
+		if doc.Tx != nil {
+			txs = append(txs, doc.Tx)
+		}
+		blocks = doc.Chain
+	} else if err := json.Unmarshal(raw, &blocks); err != nil {
+		return fmt.Errorf("%s: not a store, tx info or chain document", args[1])
+	}
+
+	type result struct {
+		ID       string `json:"id"`
+		KeyID    string `json:"key_id,omitempty"`
+		Valid    bool   `json:"valid"`
+		Tier     *bool  `json:"tier_signature_valid,omitempty"`
+		Rotation *bool  `json:"rotation_valid,omitempty"`
+	}
+	var txRes, blkRes []result
+	allOK := true
+	for _, tx := range txs {
+		res := result{ID: tx.TxID, KeyID: tx.TxKeyID, Valid: keys.verifyTx(tx)}
+		if tx.SignatureAlg == sigAlgEd25519 {
+			v, _ := keys.verifyTier(tx)
+			res.Tier = &v
+			allOK = allOK && v
+		}
+		if tx.KeyRotation != nil {
+			v := keys.verifyRotation(tx.KeyRotation)
+			res.Rotation = &v
+			allOK = allOK && v
+		}
+		allOK = allOK && res.Valid
+		txRes = append(txRes, res)
+	}
+	for _, b := range blocks {
+		ok := b.Signature != "" && computeBlockHash(b) == b.Hash && keys.verifyBlock(b)
+		allOK = allOK && ok
+		blkRes = append(blkRes, result{ID: fmt.Sprint(b.Index), KeyID: b.KeyID, Valid: ok})
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(map[string]any{"all_valid": allOK, "transactions": txRes, "blocks": blkRes}); err != nil {
+		return err
+	}
+	if !allOK {
+		return errors.New("some signatures did not verify")
+	}
+	return nil
+}
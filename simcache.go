@@ -0,0 +1,317 @@
+package main
+
+import (
+	"compress/gzip"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Симуляции не хранятся в сторе (storedTx их вырезает), а после рестарта
+// /tx/{id}/png и /tx/{id}/json должны работать. simulationFor достаёт
+// симуляцию транзакции по порядку:
+//
+//	1. LRU-кэш в памяти, ограниченный SIM_CACHE_MB (по умолчанию 256);
+//	2. сжатый файл sims/<tx_id>.json.gz рядом со стором, если SIM_SIDECAR=on;
+//	3. повторный runSimulation(tx.Seed, paramsFromTx(tx)).
+//
+// Всё, что не из кэша, сверяется с tx.DataHash: digest пересчитывается по
+// траекториям (simPathDigest) в том же порядке, в каком его считает
+// runSimulation, так что подменённый или битый sidecar не будет отдан.
+
+const defaultSimCacheMB = 256
+
+var (
+	errSimMismatch = errors.New("simulation does not match data_hash")
+	errSimTooLarge = errors.New("simulation is larger than SIM_CACHE_MB")
+)
+
+type simEntry struct {
+	id   string
+	sim  SimulationData
+	size int64
+}
+
+// simLRU — кэш симуляций, ограниченный оценкой занимаемой памяти.
+type simLRU struct {
+	mu    sync.Mutex
+	limit int64
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+	// параллельные запросы одной транзакции ждут одну пересборку
+	building map[string]*simBuild
+}
+
+type simBuild struct {
+	done chan struct{}
+	sim  SimulationData
+	err  error
+}
+
+var sims = newSimLRU(simCacheLimit())
+
+func newSimLRU(limit int64) *simLRU {
+	return &simLRU{
+		limit:    limit,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+		building: map[string]*simBuild{},
+	}
+}
+
+func simCacheLimit() int64 {
+	if s := os.Getenv("SIM_CACHE_MB"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+			return int64(n) << 20
+		}
+		log.Printf("sim: bad SIM_CACHE_MB %q, using %d", s, defaultSimCacheMB)
+	}
+	return defaultSimCacheMB << 20
+}
+
+func simSidecarEnabled() bool {
+	switch os.Getenv("SIM_SIDECAR") {
+	case "1", "on", "true", "yes":
+		return true
+	}
+	return false
+}
+
+func simSidecarPath(id string) string {
+	return filepath.Join(filepath.Dir(storePath()), "sims", id+".json.gz")
+}
+
+// simSize — грубая оценка памяти: 16 байт на точку траектории плюс заголовки.
+func simSize(sim SimulationData) int64 {
+	n := int64(256)
+	for _, p := range sim.Points {
+		n += 64 + int64(len(p.Color)) + int64(cap(p.Path))*16
+	}
+	return n
+}
+
+// simRenderable: траектории симуляции помещаются в кэш. Большие прогоны
+// (10^7 итераций) считаются только в режиме digest (simulationDigest) и не
+// рисуются — их пришлось бы пересобирать на каждый запрос.
+func simRenderable(gp GenerateParams) bool {
+	return int64(gp.NumPoints)*int64(gp.Iterations)*16 <= sims.limit
+}
+
+func (c *simLRU) get(id string) (SimulationData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[id]
+	if !ok {
+		return SimulationData{}, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*simEntry).sim, true
+}
+
+// put кладёт симуляцию в кэш и вытесняет самые старые; симуляция больше
+// всего лимита не кэшируется.
+func (c *simLRU) put(id string, sim SimulationData) {
+	sz := simSize(sim)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[id]; ok {
+		c.size -= e.Value.(*simEntry).size
+		c.ll.Remove(e)
+		delete(c.items, id)
+	}
+	if sz > c.limit {
+		return
+	}
+	c.items[id] = c.ll.PushFront(&simEntry{id: id, sim: sim, size: sz})
+	c.size += sz
+	for c.size > c.limit {
+		e := c.ll.Back()
+		ent := e.Value.(*simEntry)
+		c.ll.Remove(e)
+		delete(c.items, ent.id)
+		c.size -= ent.size
+	}
+}
+
+// keepSimulation запоминает симуляцию только что созданной транзакции:
+// в кэш и, если включено, в sidecar (в фоне — ответ его не ждёт).
+func keepSimulation(id string, sim SimulationData) {
+	sims.put(id, sim)
+	if simSidecarEnabled() {
+		go func() {
+			if err := writeSimSidecar(id, sim); err != nil {
+				log.Printf("sim: sidecar %s: %v", id, err)
+			}
+		}()
+	}
+}
+
+// simulated сообщает, есть ли у транзакции симуляция (у розыгрышей, ротаций
+// ключей и commit–reveal транзакций её нет).
+func simulated(tx *Transaction) bool {
+	return tx.DataHash != "" && tx.Provenance.NumPoints > 0 && tx.Provenance.Iterations > 0 && tx.KeyRotation == nil
+}
+
+// simulationFor возвращает симуляцию tx: из памяти, sidecar или пересборкой.
+// Для транзакций без симуляции — пустую.
+func simulationFor(tx *Transaction) (SimulationData, error) {
+	txMutex.RLock()
+	c := *tx
+	txMutex.RUnlock()
+	if len(c.Sim.Points) > 0 || !simulated(&c) {
+		return c.Sim, nil
+	}
+	if err := checkSimulationModes(&c.Provenance); err != nil {
+		return SimulationData{}, err
+	}
+	if sim, ok := sims.get(c.TxID); ok {
+		return sim, nil
+	}
+	if !simRenderable(paramsFromTx(&c)) {
+		return SimulationData{}, errSimTooLarge
+	}
+
+	sims.mu.Lock()
+	if b, ok := sims.building[c.TxID]; ok {
+		sims.mu.Unlock()
+		<-b.done
+		return b.sim, b.err
+	}
+	b := &simBuild{done: make(chan struct{})}
+	sims.building[c.TxID] = b
+	sims.mu.Unlock()
+
+	b.sim, b.err = loadSimulation(&c)
+	if b.err == nil {
+		sims.put(c.TxID, b.sim)
+	}
+	sims.mu.Lock()
+	delete(sims.building, c.TxID)
+	sims.mu.Unlock()
+	close(b.done)
+	return b.sim, b.err
+}
+
+// loadSimulation читает sidecar или пересобирает симуляцию и сверяет её с
+// DataHash.
+func loadSimulation(tx *Transaction) (SimulationData, error) {
+	if simSidecarEnabled() {
+		sim, err := readSimSidecar(tx.TxID)
+		switch {
+		case err == nil && simMatches(sim, tx.DataHash):
+			return sim, nil
+		case err == nil:
+			log.Printf("sim: sidecar %s does not match data_hash, rebuilding", tx.TxID)
+		case !errors.Is(err, os.ErrNotExist):
+			log.Printf("sim: sidecar %s: %v, rebuilding", tx.TxID, err)
+		}
+	}
+	sim, digest := runSimulation(tx.Seed, paramsFromTx(tx))
+	dh := sha256.Sum256(digest[:])
+	if hex.EncodeToString(dh[:]) != tx.DataHash {
+		return SimulationData{}, fmt.Errorf("tx %s: %w", tx.TxID, errSimMismatch)
+	}
+	log.Printf("sim: rebuilt simulation of tx %s", tx.TxID)
+	if simSidecarEnabled() {
+		if err := writeSimSidecar(tx.TxID, sim); err != nil {
+			log.Printf("sim: sidecar %s: %v", tx.TxID, err)
+		}
+	}
+	return sim, nil
+}
+
+// simPathDigest пересчитывает pathDigest по траекториям: тик за тиком, точка
+// за точкой, float64-биты x и y — как в runSimulation.
+func simPathDigest(sim SimulationData) [32]byte {
+	h := sha256.New()
+	var tmp [16]byte
+	for t := 0; t < sim.Iterations; t++ {
+		for _, p := range sim.Points {
+			if t >= len(p.Path) {
+				continue
+			}
+			binary.LittleEndian.PutUint64(tmp[:8], mathFloat64bits(p.Path[t].X))
+			binary.LittleEndian.PutUint64(tmp[8:], mathFloat64bits(p.Path[t].Y))
+			h.Write(tmp[:])
+		}
+	}
+	var digest [32]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+func simMatches(sim SimulationData, dataHash string) bool {
+	for _, p := range sim.Points {
+		if len(p.Path) != sim.Iterations {
+			return false
+		}
+	}
+	digest := simPathDigest(sim)
+	dh := sha256.Sum256(digest[:])
+	return hex.EncodeToString(dh[:]) == dataHash
+}
+
+func readSimSidecar(id string) (SimulationData, error) {
+	var sim SimulationData
+	f, err := os.Open(simSidecarPath(id))
+	if err != nil {
+		return sim, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return sim, err
+	}
+	defer zr.Close()
+	err = json.NewDecoder(zr).Decode(&sim)
+	return sim, err
+}
+
+// writeSimSidecar пишет sidecar через временный файл и rename, чтобы
+// оборванная запись не оставила полфайла.
+func writeSimSidecar(id string, sim SimulationData) error {
+	path := simSidecarPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	zw := gzip.NewWriter(tmp)
+	err = json.NewEncoder(zw).Encode(sim)
+	if err2 := zw.Close(); err == nil {
+		err = err2
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// simErrorStatus: слишком большая симуляция или неизвестный этой сборке режим —
+// ошибка запроса, расхождение с DataHash — сервера.
+func simErrorStatus(err error) int {
+	if errors.Is(err, errSimTooLarge) || errors.Is(err, errSimUnsupported) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"math/rand/v2"
+)
+
+// Версии генератора симуляции (Provenance.PRNG). Пустая строка — исходный
+// math/rand.NewSource(seed): его поток — деталь реализации Go, которую
+// math/rand/v2 уже заменил другими алгоритмами, так что он оставлен только
+// для проверки транзакций, созданных до появления prng.
+//
+// chacha8-v1 — ChaCha8 из math/rand/v2 (поток задан спецификацией C2SP
+// chacha8rand), ключ — SHA-256("sim-prng-chacha8-v1\x00" || label || "\x00"
+// || seed little-endian), где label пустой у общего rnd прогона и равен
+// имени закона у собственного rnd закона. Float64 и Intn поверх потока —
+// float64From и intN из sample.go, а не методы math/rand/v2.
+const (
+	simPRNGLegacy  = ""
+	simPRNGChaCha8 = "chacha8-v1"
+
+	// simPRNGCurrent is what new transactions use.
+	simPRNGCurrent = simPRNGChaCha8
+)
+
+var errSimUnsupported = errors.New("unsupported simulation mode")
+
+// simRand — всё, что симуляция берёт у генератора; *math/rand.Rand
+// подходит как есть.
+type simRand interface {
+	Float64() float64
+	Intn(n int) int
+}
+
+// simPRNGs: генератор по версии; label "" — общий rnd прогона, иначе имя
+// закона.
+var simPRNGs = map[string]func(seed int64, label string) simRand{
+	simPRNGLegacy:  newLegacySimRand,
+	simPRNGChaCha8: newChaCha8SimRand,
+}
+
+// simPRNGName is the display name; the legacy version has no stored name.
+func simPRNGName(prng string) string {
+	if prng == simPRNGLegacy {
+		return "math/rand"
+	}
+	return prng
+}
+
+func checkPRNG(prng string) error {
+	if _, ok := simPRNGs[prng]; !ok {
+		return fmt.Errorf("%w: prng %q", errSimUnsupported, prng)
+	}
+	return nil
+}
+
+// checkSimulationModes: транзакцию можно пересчитать этой сборкой — её
+// арифметика (floatdet.go) и генератор известны.
+func checkSimulationModes(p *GenerationProvenance) error {
+	if err := checkFloatMode(p.FloatMode); err != nil {
+		return err
+	}
+	return checkPRNG(p.PRNG)
+}
+
+// newSimRand — генератор версии prng; неизвестная версия отсекается раньше
+// (checkSimulationModes), здесь она — ошибка программы.
+func newSimRand(prng string, seed int64, label string) simRand {
+	f, ok := simPRNGs[prng]
+	if !ok {
+		panic("newSimRand: unknown prng " + prng)
+	}
+	return f(seed, label)
+}
+
+func newLegacySimRand(seed int64, label string) simRand {
+	if label == "" {
+		return mrand.New(mrand.NewSource(seed))
+	}
+	return mrand.New(mrand.NewSource(lawSeed(seed, label)))
+}
+
+type sourceRand struct{ src rand.Source }
+
+func (r sourceRand) Float64() float64 { return float64From(r.src) }
+func (r sourceRand) Intn(n int) int   { return intN(r.src, n) }
+
+func newChaCha8SimRand(seed int64, label string) simRand {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(seed))
+	key := sha256.Sum256(append([]byte("sim-prng-"+simPRNGChaCha8+"\x00"+label+"\x00"), b[:]...))
+	return sourceRand{rand.NewChaCha8(key)}
+}
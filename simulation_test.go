@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+// Эталонные digest'ы симуляции — те же simVectors, что проверяет self-test
+// при старте. CI гоняет их ещё с GOAMD64=v3 и под qemu-aarch64
+// (GOARCH=arm64): там компилятор сливает x*y+z в FMA, и любое пропущенное
+// float64() в коде симуляции меняет digest.
+func TestSimulationVectors(t *testing.T) {
+	for _, v := range simVectors {
+		t.Run(v.name, func(t *testing.T) {
+			if err := checkSimulation(v); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
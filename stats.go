@@ -1044,9 +1044,17 @@ func txStats(w http.ResponseWriter, r *http.Request, id string) {
 	}
 	// читаем байты из TRNG и распаковываем биты MSB-first, как раньше
 	nBits := tx.Count
-	tr := NewTRNGFromTx(tx)
+	tr, err := NewTRNGFromTx(tx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	needed := (nBits + 7) / 8
-	data := tr.ReadBytes(needed)
+	data, err := tr.ReadBytes(needed)
+	if err != nil {
+		http.Error(w, "trng: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 	// обнуляем лишние младшие биты
 	if nBits%8 != 0 && needed > 0 {
 		keep := uint(nBits % 8)
@@ -1342,9 +1350,29 @@ func modeFromString(s string) FileMode {
    (ОПЦ) Вспомогательный CLI
    =========================== */
 
-// Не вызывается автоматически — оставлено на случай локальной проверки.
+// Вызывается из main() до старта сервера.
 // go run . --string 0101...
+// go run . --estimate jitter 100000
+// go run . --verify-offline <pubkey-hex|keys.json> store.json
+// go run . --verify-proof keys.json proof.json
+// go run . --monitor http://localhost:4040 monitor.json 1m
+// go run . --bench-sim 200 50000
 func _maybeRunCLI(args []string) (bool, error) {
+	if len(args) > 0 && args[0] == "--estimate" {
+		return true, estimateCLI(args[1:])
+	}
+	if len(args) > 0 && args[0] == "--verify-offline" {
+		return true, verifyOfflineCLI(args[1:])
+	}
+	if len(args) > 0 && args[0] == "--verify-proof" {
+		return true, verifyProofCLI(args[1:])
+	}
+	if len(args) > 0 && args[0] == "--monitor" {
+		return true, monitorCLI(args[1:])
+	}
+	if len(args) > 0 && args[0] == "--bench-sim" {
+		return true, benchSimCLI(args[1:])
+	}
 	if len(args) == 0 || (args[0] != "--string" && args[0] != "--input") {
 		return false, nil
 	}
@@ -1386,5 +1414,5 @@ func _maybeRunCLI(args []string) (bool, error) {
 		enc.SetIndent("", "  ")
 		return true, enc.Encode(out)
 	}
-	return true, fmt.Errorf("usage: --string <bits> | --input <path> <txt|bin01|binpacked>")
+	return true, fmt.Errorf("usage: --string <bits> | --input <path> <txt|bin01|binpacked> | --estimate <source> [samples] | --verify-offline <pubkey-hex|keys.json> <file> | --verify-proof <pubkey-hex|keys.json> <proof.json> [sth.json] | --monitor <server url> <state.json> [interval] | --bench-sim [points] [iter] [workers]")
 }
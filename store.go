@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps transactions and the block chain. Handlers go through the
+// package-level store; the backend is chosen by STORE_BACKEND:
+//
+//	json — in memory, WAL + store.json snapshots (jsonstore.go, wal.go); default
+//	bolt — bbolt B+tree file store.db (boltstore.go)
+//	log  — append-only record files in store.log/ with an in-memory offset
+//	       index, no dependencies (logstore.go)
+//
+// Transactions are stored without their simulation; simulationFor
+// (simcache.go) caches or rebuilds it.
+type Store interface {
+	// PutTx inserts or replaces a transaction.
+	PutTx(tx *Transaction) error
+	// GetTx returns errTxNotFound for unknown ids.
+	GetTx(id string) (*Transaction, error)
+	// ListTx returns up to limit transactions with ids after cursor, in id
+	// order, and the cursor of the next page ("" after the last one).
+	ListTx(cursor string, limit int) ([]*Transaction, string, error)
+	// AppendBlock stores tx and its block together; b.Index must be the
+	// current chain length.
+	AppendBlock(tx *Transaction, b Block) error
+	// Blocks returns blocks [from, to), clipped to the chain.
+	Blocks(from, to int) ([]Block, error)
+	// Head returns the chain length and the last block.
+	Head() (int, Block, error)
+	// Keyring and PutKeyring hold the signing keyring with sealed secrets.
+	Keyring() ([]persistedKey, error)
+	PutKeyring(keys []persistedKey) error
+	Close() error
+}
+
+var (
+	errTxNotFound     = errors.New("tx not found")
+	errBlockConflict  = errors.New("block index does not extend the chain")
+	errUnknownBackend = errors.New("unknown STORE_BACKEND")
+)
+
+var store Store
+
+// appendMu serializes appendBlock: reading the head and appending the next
+// block must not interleave.
+var appendMu sync.Mutex
+
+func storeBackend() string {
+	if b := os.Getenv("STORE_BACKEND"); b != "" {
+		return b
+	}
+	return "json"
+}
+
+func openBackend(name string) (Store, error) {
+	dir := filepath.Dir(storePath())
+	switch name {
+	case "json":
+		return openJSONStore(storePath())
+	case "bolt":
+		return openBoltStore(filepath.Join(dir, "store.db"))
+	case "log":
+		return openLogStore(filepath.Join(dir, "store.log"))
+	}
+	return nil, fmt.Errorf("%w %q (json, bolt, log)", errUnknownBackend, name)
+}
+
+// openStore opens the configured backend. A new, empty bolt or log store
+// imports the json store (store.json + wal/) if there is one.
+func openStore() error {
+	name := storeBackend()
+	s, err := openBackend(name)
+	if err != nil {
+		return err
+	}
+	if name != "json" {
+		if err := importJSONStore(s); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	store = s
+	return nil
+}
+
+func importJSONStore(dst Store) error {
+	n, _, err := dst.Head()
+	if err != nil || n > 0 {
+		return err
+	}
+	if _, err := os.Stat(storePath()); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	src, err := openJSONStore(storePath())
+	if err != nil {
+		return fmt.Errorf("import %s: %w", storePath(), err)
+	}
+	defer src.Close()
+	// moves single-key fields of old stores into the keyring first
+	if err := loadKeyring(src); err != nil {
+		return fmt.Errorf("import %s: %w", storePath(), err)
+	}
+	if err := copyStore(dst, src); err != nil {
+		return fmt.Errorf("import %s: %w", storePath(), err)
+	}
+	n, _, _ = dst.Head()
+	log.Printf("store: imported %d blocks from %s", n, storePath())
+	return nil
+}
+
+// copyStore copies keyring, transactions and blocks from src to dst.
+func copyStore(dst, src Store) error {
+	keys, err := src.Keyring()
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		if err := dst.PutKeyring(keys); err != nil {
+			return err
+		}
+	}
+	cursor := ""
+	for {
+		txs, next, err := src.ListTx(cursor, 1024)
+		if err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			if err := dst.PutTx(tx); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	size, _, err := src.Head()
+	if err != nil {
+		return err
+	}
+	for from := 0; from < size; from += 1024 {
+		blocks, err := src.Blocks(from, from+1024)
+		if err != nil {
+			return err
+		}
+		for _, b := range blocks {
+			tx, err := src.GetTx(b.TxID)
+			if err != nil {
+				return fmt.Errorf("block %d: %w", b.Index, err)
+			}
+			if err := dst.AppendBlock(tx, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// legacyKeyStore is a store that may still hold the single-key fields of
+// stores written before the keyring (json only).
+type legacyKeyStore interface {
+	legacyKeys() (signingKey, ed25519Key string)
+}
+
+// chainLen is the number of blocks; errors count as an empty chain.
+func chainLen() int {
+	n, _, err := store.Head()
+	if err != nil {
+		log.Printf("store: %v", err)
+	}
+	return n
+}
+
+// scanBlocks calls fn for blocks [from, to) in pages; fn returns false to stop.
+func scanBlocks(from, to int, fn func(Block) bool) error {
+	const page = 1024
+	for ; from < to; from += page {
+		blocks, err := store.Blocks(from, min(from+page, to))
+		if err != nil {
+			return err
+		}
+		if len(blocks) == 0 {
+			return nil
+		}
+		for _, b := range blocks {
+			if !fn(b) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// findTxBlock finds the newest block among the first size that publishes
+// tx's current state (for a commit–reveal draw — the result block).
+func findTxBlock(id, published string, size int) (Block, bool, error) {
+	const page = 1024
+	for to := size; to > 0; to -= page {
+		blocks, err := store.Blocks(max(to-page, 0), to)
+		if err != nil {
+			return Block{}, false, err
+		}
+		for i := len(blocks) - 1; i >= 0; i-- {
+			if blocks[i].TxID == id && blocks[i].DataHash == published {
+				return blocks[i], true, nil
+			}
+		}
+	}
+	return Block{}, false, nil
+}
+
+// storedTx is tx as backends persist it: a copy without the simulation.
+func storedTx(tx *Transaction) Transaction {
+	txMutex.RLock()
+	c := *tx
+	txMutex.RUnlock()
+	c.Sim = SimulationData{}
+	return c
+}
@@ -0,0 +1,142 @@
+//go:build tools
+
+// beacon_stub — локальный маяк в формате drand HTTP API для тестов
+// entropy=beacon и commit–reveal розыгрышей с beacon.
+//
+//	go run -tags tools ./tools/beacon_stub.go -addr :8081 -period 3
+//
+// Раунды детерминированы ключом (-key): схема pedersen-bls-chained, как у
+// цепочки drand по умолчанию — секрет BLS = SHA-256(key) mod r, публичный
+// ключ в G1, signature = sk·H(SHA-256(previous_signature || round)) в G2,
+// randomness = SHA-256(signature). Раунд r публикуется в genesis +
+// (r-1)*period; будущие раунды отдают 404. Те же пути отдаются с префиксом
+// /{chain hash}, как у drand.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bls "github.com/cloudflare/circl/ecc/bls12381"
+)
+
+const dstG2 = "BLS_SIG_BLS12381G2_XMD:SHA-256_SSWU_RO_NUL_"
+
+type round struct {
+	Round             uint64 `json:"round"`
+	Randomness        string `json:"randomness"`
+	Signature         string `json:"signature"`
+	PreviousSignature string `json:"previous_signature"`
+}
+
+func main() {
+	addr := flag.String("addr", ":8081", "listen address")
+	period := flag.Int("period", 3, "seconds between rounds")
+	key := flag.String("key", "beacon-stub", "key the round chain is derived from")
+	genesisFlag := flag.Int64("genesis", 0, "genesis unix time (default: now)")
+	flag.Parse()
+
+	genesis := *genesisFlag
+	if genesis == 0 {
+		genesis = time.Now().Unix()
+	}
+
+	seed := sha256.Sum256([]byte(*key))
+	sk := new(bls.Scalar)
+	sk.SetBytes(seed[:])
+	pk := new(bls.G1)
+	pk.ScalarMult(sk, bls.G1Generator())
+
+	var mu sync.Mutex
+	sigs := [][]byte{nil} // sigs[r] = signature of round r; sigs[0] is the empty genesis signature
+	roundSig := func(r uint64) (sig, prev []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		for uint64(len(sigs)) <= r {
+			n := uint64(len(sigs))
+			var rb [8]byte
+			binary.BigEndian.PutUint64(rb[:], n)
+			msg := sha256.Sum256(append(append([]byte(nil), sigs[n-1]...), rb[:]...))
+			h := new(bls.G2)
+			h.Hash(msg[:], []byte(dstG2))
+			sig := new(bls.G2)
+			sig.ScalarMult(sk, h)
+			sigs = append(sigs, sig.BytesCompressed())
+		}
+		return sigs[r], sigs[r-1]
+	}
+	current := func() uint64 {
+		now := time.Now().Unix()
+		if now < genesis {
+			return 0
+		}
+		return uint64((now-genesis)/int64(*period)) + 1
+	}
+	writeJSON := func(w http.ResponseWriter, v any) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	chainHash := sha256.Sum256([]byte("chain:" + *key))
+	chain := hex.EncodeToString(chainHash[:])
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"public_key":   hex.EncodeToString(pk.BytesCompressed()),
+			"period":       *period,
+			"genesis_time": genesis,
+			"hash":         chain,
+			"schemeID":     "pedersen-bls-chained",
+		})
+	})
+	mux.HandleFunc("/public/", func(w http.ResponseWriter, r *http.Request) {
+		cur := current()
+		p := strings.TrimPrefix(r.URL.Path, "/public/")
+		rn := cur
+		if p != "latest" {
+			v, err := strconv.ParseUint(p, 10, 64)
+			if err != nil || v == 0 {
+				http.Error(w, "bad round", http.StatusBadRequest)
+				return
+			}
+			rn = v
+		}
+		if rn == 0 || rn > cur {
+			http.Error(w, "round not available yet", http.StatusNotFound)
+			return
+		}
+		sig, prev := roundSig(rn)
+		rnd := sha256.Sum256(sig)
+		writeJSON(w, round{
+			Round:             rn,
+			Randomness:        hex.EncodeToString(rnd[:]),
+			Signature:         hex.EncodeToString(sig),
+			PreviousSignature: hex.EncodeToString(prev),
+		})
+	})
+
+	// /{chain hash}/... — the same chain, as drand serves it
+	root := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := strings.CutPrefix(r.URL.Path, "/"+chain); ok {
+			r2 := *r
+			u := *r.URL
+			u.Path = rest
+			r2.URL = &u
+			r = &r2
+		}
+		mux.ServeHTTP(w, r)
+	})
+
+	log.Printf("beacon stub on %s (period=%ds, genesis=%d, chain %s)", *addr, *period, genesis, chain)
+	log.Fatal(http.ListenAndServe(*addr, root))
+}
@@ -2,15 +2,52 @@ package main
 
 import (
 	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
 )
 
-// TRNG implemented via HMAC-DRBG seeded with seed || perSeeds
+// TRNG implemented via a DRBG (HMAC_DRBG by default) seeded with seed || perSeeds
 type TRNG struct {
-	drbg *HMACDRBG
+	drbg DRBG
+	// legacy: транзакции без Provenance.DRBG читают весь запрос одним
+	// generate без лимита SP 800-90A, как до версионирования DRBG; иначе
+	// поток длиннее drbgMaxRequestBytes не совпал бы с сохранённым.
+	legacy bool
+
+	// prediction resistance: если источник задан, DRBG пересеивается
+	// свежими сырыми байтами перед каждым запросом. Вывод тогда уже не
+	// воспроизводится из seed.
+	prSource EntropySource
+	prSpec   EntropySpec
 }
 
 func NewTRNGFromSeed(seed int64, per []int64) *TRNG {
-	// build seed material: seed||perSeeds
+	return &TRNG{drbg: newHMACDRBG(trngSeedMaterial(seed, per)), legacy: true}
+}
+
+// NewTRNG builds the TRNG on the named DRBG mechanism. "" is the legacy
+// hmac-sha256 stream of transactions recorded before the drbg field; new
+// transactions always name the mechanism. Seed material is the same for
+// every mechanism and goes in as entropy input.
+func NewTRNG(mech string, seed int64, per []int64) (*TRNG, error) {
+	if mech == "" {
+		return NewTRNGFromSeed(seed, per), nil
+	}
+	mk, ok := drbgMechanisms[mech]
+	if !ok {
+		return nil, fmt.Errorf("unknown drbg %q", mech)
+	}
+	return &TRNG{drbg: mk(trngSeedMaterial(seed, per), nil, nil)}, nil
+}
+
+func NewTRNGFromTx(tx *Transaction) (*TRNG, error) {
+	return NewTRNG(tx.Provenance.DRBG, tx.Seed, tx.Provenance.PerHTTPSeeds)
+}
+
+// trngSeedMaterial: seed||perSeeds, little-endian.
+func trngSeedMaterial(seed int64, per []int64) []byte {
 	buf := make([]byte, 8*(1+len(per)))
 	binary.LittleEndian.PutUint64(buf[0:8], uint64(seed))
 	off := 8
@@ -18,14 +55,110 @@ func NewTRNGFromSeed(seed int64, per []int64) *TRNG {
 		binary.LittleEndian.PutUint64(buf[off:off+8], uint64(per[i]))
 		off += 8
 	}
-	drbg := newHMACDRBG(buf)
-	return &TRNG{drbg: drbg}
+	return buf
+}
+
+// EnablePredictionResistance makes every DRBG request start with a reseed
+// from src. Sources that claim no min-entropy (repro, http) are rejected.
+func (t *TRNG) EnablePredictionResistance(src EntropySource, es EntropySpec) error {
+	if src.MinEntropy() <= 0 {
+		return fmt.Errorf("entropy source %q claims no min-entropy", src.Name())
+	}
+	t.prSource, t.prSpec = src, es
+	return nil
+}
+
+// reseed pulls enough raw bytes from the PR source to cover the security
+// strength at the source's claimed min-entropy per byte.
+func (t *TRNG) reseed() error {
+	need := int(math.Ceil(8 * drbgSecurityStrength / t.prSource.MinEntropy()))
+	need = max(need, drbgSecurityStrength)
+	raw, err := collectSamples(t.prSource, t.prSpec, need)
+	if err != nil {
+		return fmt.Errorf("prediction resistance: %w", err)
+	}
+	return t.drbg.Reseed(raw, nil)
+}
+
+// ReadBytes returns n bytes, split into requests of at most
+// drbgMaxRequestBytes (the legacy stream takes any n in one request).
+func (t *TRNG) ReadBytes(n int) ([]byte, error) {
+	if t.legacy && t.prSource == nil {
+		return t.drbg.(*HMACDRBG).generate(n, nil), nil
+	}
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		if t.prSource != nil {
+			if err := t.reseed(); err != nil {
+				return nil, err
+			}
+		}
+		b, err := t.drbg.Generate(min(n-len(out), drbgMaxRequestBytes), nil)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b...)
+	}
+	return out, nil
+}
+
+var (
+	_ io.Reader   = (*TRNG)(nil)
+	_ rand.Source = (*TRNG)(nil)
+)
+
+// Read implements io.Reader: len(p) bytes as one ReadBytes call.
+func (t *TRNG) Read(p []byte) (int, error) {
+	b, err := t.ReadBytes(len(p))
+	if err != nil {
+		return 0, err
+	}
+	return copy(p, b), nil
 }
 
-func NewTRNGFromTx(tx *Transaction) *TRNG {
-	return NewTRNGFromSeed(tx.Seed, tx.Provenance.PerHTTPSeeds)
+// trngFailure carries a DRBG error out of Uint64, which has no error result.
+type trngFailure struct{ err error }
+
+// Uint64 implements math/rand/v2.Source: one 8-byte DRBG request,
+// little-endian (как и прежний розыгрыш tier). On a DRBG error it panics
+// with trngFailure; wrap draws in catchTRNG to get the error back.
+func (t *TRNG) Uint64() uint64 {
+	b, err := t.ReadBytes(8)
+	if err != nil {
+		panic(trngFailure{err})
+	}
+	return binary.LittleEndian.Uint64(b)
 }
 
-func (t *TRNG) ReadBytes(n int) []byte {
-	return t.drbg.Generate(n)
+// catchTRNG runs f and returns the DRBG error if a TRNG inside it failed.
+func catchTRNG(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			tf, ok := r.(trngFailure)
+			if !ok {
+				panic(r)
+			}
+			err = tf.err
+		}
+	}()
+	f()
+	return nil
 }
+
+// IntN returns a uniform int in [0, n) without modulo bias. Panics if n <= 0.
+func (t *TRNG) IntN(n int) int { return intN(t, n) }
+
+// Float64 returns a uniform float64 in [0, 1).
+func (t *TRNG) Float64() float64 { return float64From(t) }
+
+// Shuffle permutes n elements via swap (Fisher–Yates on IntN).
+func (t *TRNG) Shuffle(n int, swap func(i, j int)) { shuffle(t, n, swap) }
+
+// Perm returns a random permutation of [0, n).
+func (t *TRNG) Perm(n int) []int { return perm(t, n) }
+
+// NormFloat64 returns a standard normal variate.
+func (t *TRNG) NormFloat64() float64 { return normFloat64(t) }
+
+// ExpFloat64 returns an exponential variate with rate 1.
+func (t *TRNG) ExpFloat64() float64 { return expFloat64(t) }
@@ -36,13 +36,26 @@ type EntropySpec struct {
 	Mode   string   `json:"mode"`   // os|jitter|http/mix/repro
 	Seed64 int64    `json:"seed64"` // используется только при mode=repro
 	HTTP   []string `json:"http"`
+	// beacon mode: drand-style base URL and round (0 = latest)
+	Beacon string `json:"beacon,omitempty"`
+	Round  uint64 `json:"round,omitempty"`
 }
 
 type MotionSpec struct {
-	Law        string  // sine|jerk|spiral|flow
+	Law        string  // закон из motionLaws (motionlaw.go), список через запятую или random
 	Sharpness  float64 // 0..2
 	Smoothness float64 // 0..2
 	SpeedScale float64 // 0..3
+	// параметры законов "<закон>.<параметр>" (lorenz.rho); пусто у законов
+	// без своих параметров и у старых транзакций
+	Params map[string]float64 `json:",omitempty"`
+	// взаимодействие точек (interact.go); нули — точки независимы, как раньше
+	Collide       bool    `json:",omitempty"` // упругие столкновения
+	CollideRadius float64 `json:",omitempty"` // радиус точки, px
+	Attract       float64 `json:",omitempty"` // >0 притяжение, <0 отталкивание
+	AttractRange  float64 `json:",omitempty"` // px
+	Flock         float64 `json:",omitempty"` // вес сил boids
+	FlockRange    float64 `json:",omitempty"` // px
 }
 
 type GenerateParams struct {
@@ -56,6 +69,8 @@ type GenerateParams struct {
 	Motion     MotionSpec
 	Step       float64 // шаг времени для симуляции
 	Whiten     string  // off|on|hmac|aes|hybrid
+	DRBG       string  // hmac-sha256|ctr-aes256|hash-sha256|hash-sha512; "" = hmac-sha256
+	PRNG       string  // генератор симуляции: chacha8-v1; "" = math/rand (старые транзакции)
 }
 
 type GenerationProvenance struct {
@@ -69,6 +84,21 @@ type GenerationProvenance struct {
 	Step         float64     `json:"step"`
 	Whiten       string      `json:"whiten"`
 	PerHTTPSeeds []int64     `json:"per_http_seeds,omitempty"`
+	// sub-sources excluded from the mix by SP 800-90B health tests
+	HealthFailures []string `json:"health_failures,omitempty"`
+	// tier draw used prediction resistance: numbers can't be replayed from Seed
+	PredictionResistance bool `json:"prediction_resistance,omitempty"`
+	// DRBG mechanism behind the TRNG; empty for older transactions: the
+	// legacy hmac-sha256 stream, one request per read whatever its size
+	DRBG string `json:"drbg,omitempty"`
+	// beacon round mixed into the seed (entropy=beacon or a beacon draw)
+	BeaconRound uint64 `json:"beacon_round,omitempty"`
+	// arithmetic of the simulation (floatdet.go); "" — before modes, same as strict-v1
+	FloatMode string `json:"float_mode,omitempty"`
+	// simulation PRNG (simrand.go); "" — math/rand, kept only to verify old transactions
+	PRNG string `json:"prng,omitempty"`
+	// diagnostics checked against the chaos gate of /generate (chaos.go)
+	Chaos *ChaosSummary `json:"chaos,omitempty"`
 }
 
 type Transaction struct {
@@ -84,7 +114,51 @@ type Transaction struct {
 	// Tier (lottery/draw) related fields
 	TierNumbers []int  `json:"tier_numbers,omitempty"`
 	TierWinners []int  `json:"tier_winners,omitempty"`
-	Signature   string `json:"signature,omitempty"` // HMAC-SHA256 signature over tier payload
+	Signature   string `json:"signature,omitempty"` // tier payload signature, see SignatureAlg
+	// "" — HMAC-SHA256 (старые розыгрыши), "ed25519" — ключ с /keys
+	SignatureAlg string `json:"signature_alg,omitempty"`
+	KeyID        string `json:"key_id,omitempty"` // keyring key of Signature
+	// [min, max] of the draw and the shuffle version (see draw.go); both
+	// empty for draws made before they were recorded
+	TierRange     []int  `json:"tier_range,omitempty"`
+	DrawAlgorithm string `json:"draw_algorithm,omitempty"`
+	// commit–reveal draw state (draw.go); nil for ordinary transactions
+	Draw *DrawCommit `json:"draw,omitempty"`
+	// Ed25519 over the transaction without simulation and this field (signer.go)
+	TxSignature string `json:"tx_signature,omitempty"`
+	TxKeyID     string `json:"tx_key_id,omitempty"`
+	// set only on the transaction that records a signing key rotation
+	KeyRotation *KeyRotation `json:"key_rotation,omitempty"`
+}
+
+// KeyRotation publishes a new signing key; the previous key signs it.
+type KeyRotation struct {
+	KeyID         string    `json:"key_id"`
+	Alg           string    `json:"alg"`
+	PublicKey     string    `json:"public_key"`
+	ActivatedAt   time.Time `json:"activated_at"`
+	PrevKeyID     string    `json:"prev_key_id,omitempty"`
+	PrevSignature string    `json:"prev_signature,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+type DrawCommit struct {
+	Commitment  string     `json:"commitment"` // hex SHA-256(seed material || salt)
+	T           int        `json:"t"`          // number of winners
+	Deadline    *time.Time `json:"deadline,omitempty"`
+	CommittedAt time.Time  `json:"committed_at"`
+	Salt        string     `json:"salt,omitempty"` // hex, disclosed at reveal
+	RevealedAt  *time.Time `json:"revealed_at,omitempty"`
+	// beacon round agreed at commit; its value is appended to the per-seeds at reveal
+	Beacon          string     `json:"beacon,omitempty"`
+	BeaconRound     uint64     `json:"beacon_round,omitempty"`
+	BeaconRoundTime *time.Time `json:"beacon_round_time,omitempty"`
+	// beacon chain pinned at commit (drand /info hash, public_key and
+	// schemeID): reveal and verify-draw take the round only from this chain
+	// and check its BLS signature with this key
+	BeaconChainHash string `json:"beacon_chain_hash,omitempty"`
+	BeaconPublicKey string `json:"beacon_public_key,omitempty"`
+	BeaconScheme    string `json:"beacon_scheme,omitempty"`
 }
 
 type Block struct {
@@ -94,4 +168,6 @@ type Block struct {
 	DataHash  string `json:"data_hash"` // published
 	PrevHash  string `json:"prev_hash"`
 	Hash      string `json:"hash"`
+	Signature string `json:"signature,omitempty"` // Ed25519 over Hash
+	KeyID     string `json:"key_id,omitempty"`
 }
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Журнал (WAL) транзакций и блоков рядом со store.json, в каталоге wal/:
+// сегменты 00000001.wal, 00000002.wal, ... Каждая запись:
+//
+//	length uint32 LE | crc32c(type || payload) uint32 LE | type byte | payload
+//
+// length считает type и payload. Типы: tx (JSON транзакции без симуляции,
+// последняя запись по tx_id побеждает), block (JSON блока), keyring (связка
+// с запечатанными секретами, как в store.json). appendBlock пишет tx и блок
+// одним write и делает fsync до ответа клиенту; в память запись попадает
+// только после fsync.
+//
+// Журналом пользуется бэкенд json (jsonstore.go). store.json — сжатый
+// снимок: раз в WAL_SNAPSHOT_EVERY записей (по умолчанию 1024) и после
+// каждой смены связки jsonStore.snapshot переключает журнал на новый сегмент,
+// пишет снимок с wal_segment = номер этого сегмента и удаляет более старые.
+// При старте openJSONStore читает снимок и проигрывает сегменты начиная с
+// wal_segment; оборванная запись в хвосте последнего сегмента отрезается.
+// Старый store.json без wal_segment и есть начальный снимок.
+
+const (
+	walRecTx      byte = 1
+	walRecBlock   byte = 2
+	walRecKeyring byte = 3
+
+	walHeaderSize        = 8
+	walMaxRecord         = 64 << 20
+	walSegmentSize       = 16 << 20
+	defaultSnapshotEvery = 1024
+)
+
+var walCRC = crc32.MakeTable(crc32.Castagnoli)
+
+var errWALClosed = errors.New("wal: not open")
+
+type walLog struct {
+	mu      sync.Mutex
+	dir     string
+	seg     uint64 // segment being appended to
+	f       *os.File
+	size    int64
+	records int   // appended since the last snapshot
+	err     error // sticky: the segment could not be restored after a failed append
+}
+
+type walRecord struct {
+	typ     byte
+	payload []byte
+}
+
+func walDir() string {
+	return filepath.Join(filepath.Dir(storePath()), "wal")
+}
+
+func walSegmentPath(dir string, n uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d.wal", n))
+}
+
+func snapshotEvery() int {
+	if s := os.Getenv("WAL_SNAPSHOT_EVERY"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			return n
+		}
+		log.Printf("wal: bad WAL_SNAPSHOT_EVERY %q, using %d", s, defaultSnapshotEvery)
+	}
+	return defaultSnapshotEvery
+}
+
+// listWALSegments returns segment numbers in dir in ascending order.
+func listWALSegments(dir string) ([]uint64, error) {
+	ents, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var segs []uint64
+	for _, e := range ents {
+		name, ok := strings.CutSuffix(e.Name(), ".wal")
+		if !ok || e.IsDir() {
+			continue
+		}
+		if n, err := strconv.ParseUint(name, 10, 64); err == nil {
+			segs = append(segs, n)
+		}
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i] < segs[j] })
+	return segs, nil
+}
+
+func encodeWALRecord(typ byte, v any) ([]byte, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, walHeaderSize+1+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:], uint32(1+len(payload)))
+	buf[walHeaderSize] = typ
+	copy(buf[walHeaderSize+1:], payload)
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(buf[walHeaderSize:], walCRC))
+	return buf, nil
+}
+
+// scanWALRecords reads records from r up to the first torn or corrupt one
+// and returns the offset where the good prefix ends. Each record is passed
+// to fn with its offset; an error from fn stops the scan.
+func scanWALRecords(r io.Reader, fn func(off int64, rec walRecord) error) (int64, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	var hdr [walHeaderSize]byte
+	var off int64
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return off, nil
+		}
+		n := int(binary.LittleEndian.Uint32(hdr[0:]))
+		if n < 1 || n > walMaxRecord {
+			return off, nil
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(br, body); err != nil {
+			return off, nil
+		}
+		if crc32.Checksum(body, walCRC) != binary.LittleEndian.Uint32(hdr[4:]) {
+			return off, nil
+		}
+		if err := fn(off, walRecord{typ: body[0], payload: body[1:]}); err != nil {
+			return off, err
+		}
+		off += int64(walHeaderSize + n)
+	}
+}
+
+// readWALSegment returns the records of a segment up to the first torn or
+// corrupt one, and the offset where the good prefix ends.
+func readWALSegment(path string) ([]walRecord, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var recs []walRecord
+	good, err := scanWALRecords(f, func(_ int64, rec walRecord) error {
+		recs = append(recs, rec)
+		return nil
+	})
+	return recs, good, err
+}
+
+// openWAL opens segment seg for appending, creating dir and the file.
+func openWAL(dir string, seg uint64) (*walLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &walLog{dir: dir}
+	if err := w.openSegment(seg); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// openSegment switches to segment n. Caller holds w.mu (or owns w).
+func (w *walLog) openSegment(n uint64) error {
+	f, err := os.OpenFile(walSegmentPath(w.dir, n), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		f.Close()
+		return err
+	}
+	if w.f != nil {
+		w.f.Close()
+	}
+	w.f, w.seg, w.size = f, n, st.Size()
+	return nil
+}
+
+// append writes records in one write and fsyncs. A failed write is cut
+// back off the segment, so nothing is left behind it for later records to
+// follow; if that fails too, or fsync fails (the page cache state is then
+// unknown), the log refuses all further appends.
+func (w *walLog) append(recs ...[]byte) error {
+	if w == nil {
+		return errWALClosed
+	}
+	var buf []byte
+	for _, r := range recs {
+		buf = append(buf, r...)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	if w.size > 0 && w.size+int64(len(buf)) > walSegmentSize {
+		if err := w.f.Sync(); err != nil {
+			return w.fail(err)
+		}
+		if err := w.openSegment(w.seg + 1); err != nil {
+			return err
+		}
+	}
+	if n, err := w.f.Write(buf); err != nil {
+		if n > 0 {
+			if terr := w.f.Truncate(w.size); terr != nil {
+				return w.fail(fmt.Errorf("%w (truncate: %v)", err, terr))
+			}
+		}
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return w.fail(err)
+	}
+	w.size += int64(len(buf))
+	w.records += len(recs)
+	return nil
+}
+
+// fail makes err sticky. Caller holds w.mu.
+func (w *walLog) fail(err error) error {
+	w.err = fmt.Errorf("wal: %s: %w; restart to recover", walSegmentPath(w.dir, w.seg), err)
+	log.Print(w.err)
+	return w.err
+}
+
+// rotate starts a new segment (unless the current one is empty) and
+// returns its number: everything logged before is in older segments.
+func (w *walLog) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.records = 0
+	if w.size == 0 {
+		return w.seg, nil
+	}
+	if err := w.f.Sync(); err != nil {
+		return 0, w.fail(err)
+	}
+	if err := w.openSegment(w.seg + 1); err != nil {
+		return 0, err
+	}
+	return w.seg, nil
+}
+
+// trim removes segments older than seg (they are covered by a snapshot).
+func (w *walLog) trim(seg uint64) {
+	segs, err := listWALSegments(w.dir)
+	if err != nil {
+		log.Printf("wal: %v", err)
+		return
+	}
+	for _, n := range segs {
+		if n < seg {
+			if err := os.Remove(walSegmentPath(w.dir, n)); err != nil {
+				log.Printf("wal: %v", err)
+			}
+		}
+	}
+}
+
+func (w *walLog) due() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.records >= snapshotEvery()
+}
+
+func (w *walLog) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}